@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+func TestAccessLog(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, nil))
+	defer func(l *slog.Logger) { slog.SetDefault(l) }(slog.Default())
+	slog.SetDefault(logger)
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	r.HandleFunc("/catpics/{id}", func(w http.ResponseWriter, r *http.Request) {
+		serverError(w, r, "Server error", errors.New("disk on fire"))
+	}).Methods("GET")
+	handler := accessLog(logger, r)
+
+	serve := func(url, requestID string) (*httptest.ResponseRecorder, []map[string]interface{}) {
+		buf.Reset()
+		req, _ := http.NewRequest("GET", url, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		if requestID != "" {
+			req.Header.Set("X-Request-ID", requestID)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var records []map[string]interface{}
+		dec := json.NewDecoder(&buf)
+		for dec.More() {
+			var rec map[string]interface{}
+			if err := dec.Decode(&rec); err != nil {
+				t.Fatal(err)
+			}
+			records = append(records, rec)
+		}
+		return rr, records
+	}
+
+	rr, records := serve("/catpics/abc", "client-id-1")
+	if got := rr.Header().Get("X-Request-ID"); got != "client-id-1" {
+		t.Errorf("got X-Request-ID %q, want the client's", got)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d log records, want an error and an access log: %v", len(records), records)
+	}
+	if errRec := records[0]; errRec["level"] != "ERROR" || errRec["request_id"] != "client-id-1" || errRec["error"] != "disk on fire" {
+		t.Errorf("got error record %v", errRec)
+	}
+	access := records[1]
+	for key, want := range map[string]interface{}{
+		"msg":        "request",
+		"request_id": "client-id-1",
+		"method":     "GET",
+		"route":      "/catpics/{id}",
+		"path":       "/catpics/abc",
+		"status":     float64(http.StatusInternalServerError),
+		"bytes":      float64(rr.Body.Len()),
+		"client":     "192.0.2.1",
+	} {
+		if access[key] != want {
+			t.Errorf("access log %s: got %v want %v", key, access[key], want)
+		}
+	}
+	if _, ok := access["latency_ms"].(float64); !ok {
+		t.Errorf("access log has no latency: %v", access)
+	}
+
+	t.Run("Generated ID", func(t *testing.T) {
+		for _, clientID := range []string{"", "has spaces", string(bytes.Repeat([]byte("x"), maxRequestIDLength+1))} {
+			rr, records := serve("/nowhere", clientID)
+			id := rr.Header().Get("X-Request-ID")
+			if _, err := uuid.Parse(id); err != nil {
+				t.Errorf("client ID %q: got X-Request-ID %q, want a generated UUID", clientID, id)
+			}
+			if len(records) != 1 || records[0]["request_id"] != id || records[0]["status"] != float64(http.StatusNotFound) || records[0]["route"] != "" {
+				t.Errorf("client ID %q: got records %v", clientID, records)
+			}
+		}
+	})
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestAlbums(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"id1", "id2", "id3"} {
+		if err := insertCatPic(db, id, []byte("test data "+id)); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+	r.HandleFunc("/albums", ListAlbums(db)).Methods("GET")
+	r.HandleFunc("/albums", CreateAlbum(db)).Methods("POST")
+	r.HandleFunc("/albums/{id}", GetAlbum(db)).Methods("GET")
+	r.HandleFunc("/albums/{id}", UpdateAlbum(db)).Methods("PUT")
+	r.HandleFunc("/albums/{id}", DeleteAlbum(db)).Methods("DELETE")
+	r.HandleFunc("/albums/{id}/pictures", AddAlbumPicture(db)).Methods("POST")
+	r.HandleFunc("/albums/{id}/pictures/{pictureId}", RemoveAlbumPicture(db)).Methods("DELETE")
+
+	do := func(method, url string, body interface{}) *httptest.ResponseRecorder {
+		raw, _ := json.Marshal(body)
+		req, err := http.NewRequest(method, url, bytes.NewReader(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	decodeAlbum := func(rr *httptest.ResponseRecorder) Album {
+		var album Album
+		if err := json.NewDecoder(rr.Body).Decode(&album); err != nil {
+			t.Fatalf("Failed to decode album: %v", err)
+		}
+		return album
+	}
+
+	rr := do("POST", "/albums", AlbumRequest{Name: "Naps", Pictures: []string{"id2", "id1"}})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	album := decodeAlbum(rr)
+
+	t.Run("Keeps Order", func(t *testing.T) {
+		got := decodeAlbum(do("GET", "/albums/"+album.ID, nil))
+		if !reflect.DeepEqual(got.Pictures, []string{"id2", "id1"}) {
+			t.Errorf("got pictures %v, want [id2 id1]", got.Pictures)
+		}
+	})
+
+	t.Run("Rejects Unknown Pictures", func(t *testing.T) {
+		rr := do("POST", "/albums", AlbumRequest{Name: "Ghosts", Pictures: []string{"missing"}})
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Reorder And Append", func(t *testing.T) {
+		rr := do("PUT", "/albums/"+album.ID, AlbumRequest{Name: "Long naps", Pictures: []string{"id1", "id2"}})
+		if rr.Code != http.StatusOK {
+			t.Fatalf("update returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		got := decodeAlbum(do("POST", "/albums/"+album.ID+"/pictures", AlbumPictureRequest{ID: "id3"}))
+		if got.Name != "Long naps" || !reflect.DeepEqual(got.Pictures, []string{"id1", "id2", "id3"}) {
+			t.Errorf("got album %+v", got)
+		}
+	})
+
+	t.Run("Trashed Members Are Kept", func(t *testing.T) {
+		rr := do("POST", "/albums", AlbumRequest{Name: "Trash", Pictures: []string{"id1", "id2"}})
+		trash := decodeAlbum(rr)
+		if rr := do("DELETE", "/catpics/id1", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("delete returned wrong status code: got %v", rr.Code)
+		}
+		defer restoreCatPic(db, "id1")
+
+		got := decodeAlbum(do("POST", "/albums/"+trash.ID+"/pictures", AlbumPictureRequest{ID: "id3"}))
+		if !reflect.DeepEqual(got.Pictures, []string{"id2", "id3"}) {
+			t.Errorf("got pictures %v while id1 is in the trash, want [id2 id3]", got.Pictures)
+		}
+		do("PUT", "/albums/"+trash.ID, AlbumRequest{Name: "Trash", Pictures: []string{"id3", "id2"}})
+
+		if _, err := restoreCatPic(db, "id1"); err != nil {
+			t.Fatal(err)
+		}
+		got = decodeAlbum(do("GET", "/albums/"+trash.ID, nil))
+		if !reflect.DeepEqual(got.Pictures, []string{"id1", "id3", "id2"}) {
+			t.Errorf("got pictures %v after restoring id1, want [id1 id3 id2]", got.Pictures)
+		}
+	})
+
+	t.Run("List In Album Order", func(t *testing.T) {
+		do("PUT", "/albums/"+album.ID, AlbumRequest{Name: "Long naps", Pictures: []string{"id3", "id1", "id2"}})
+
+		var pics []CatPicResponse
+		json.NewDecoder(do("GET", "/catpics?album="+album.ID, nil).Body).Decode(&pics)
+		var ids []string
+		for _, pic := range pics {
+			ids = append(ids, pic.ID)
+		}
+		if want := []string{"id3", "id1", "id2"}; !reflect.DeepEqual(ids, want) {
+			t.Errorf("got pictures %v, want %v", ids, want)
+		}
+	})
+
+	t.Run("List Filtered By Album", func(t *testing.T) {
+		do("DELETE", "/albums/"+album.ID+"/pictures/id2", nil)
+		do("DELETE", "/catpics/id3", nil)
+
+		var pics []CatPicResponse
+		json.NewDecoder(do("GET", "/catpics?album="+album.ID, nil).Body).Decode(&pics)
+		if len(pics) != 1 || pics[0].ID != "id1" {
+			t.Errorf("got pictures %+v, want only id1", pics)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if rr := do("DELETE", "/albums/"+album.ID, nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("delete returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := do("GET", "/albums/"+album.ID, nil); rr.Code != http.StatusNotFound {
+			t.Errorf("get after delete returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
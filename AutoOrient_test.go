@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func orientationTIFF(orientation uint16) []byte {
+	return buildTestTIFF([]testIFDEntry{
+		asciiEntry(tagMake, "Canon"),
+		asciiEntry(tagModel, "EOS 5D"),
+		shortEntry(tagOrientation, orientation),
+	}, nil, nil)
+}
+
+func TestAutoOrient(t *testing.T) {
+	defer func(orient bool) { config.AutoOrient = orient }(config.AutoOrient)
+	config.AutoOrient = true
+
+	db := setupTestDB(t)
+	defer db.Close()
+	if err := insertCatPic(db, "id1", []byte("test data")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	// A 3x2 picture whose top-left pixel is red and top-right pixel is blue.
+	img := image.NewNRGBA(image.Rect(0, 0, 3, 2))
+	img.Set(0, 0, color.NRGBA{255, 0, 0, 255})
+	img.Set(2, 0, color.NRGBA{0, 0, 255, 255})
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+
+	tests := []struct {
+		orientation uint16
+		size        image.Point
+		red, blue   image.Point
+	}{
+		{1, image.Pt(3, 2), image.Pt(0, 0), image.Pt(2, 0)},
+		{3, image.Pt(3, 2), image.Pt(2, 1), image.Pt(0, 1)},
+		{6, image.Pt(2, 3), image.Pt(1, 0), image.Pt(1, 2)},
+		{8, image.Pt(2, 3), image.Pt(0, 2), image.Pt(0, 0)},
+	}
+
+	for _, tt := range tests {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.png")
+		part.Write(withPNGExif(encodePNG(t, img), orientationTIFF(tt.orientation)))
+		writer.Close()
+
+		req, _ := http.NewRequest("PUT", "/catpics/id1", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("orientation %d: handler returned wrong status code: got %v want %v", tt.orientation, rr.Code, http.StatusOK)
+		}
+
+		stored, err := loadCatPicData(db, "id1")
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, _, err := decodeImage(stored)
+		if err != nil {
+			t.Fatalf("orientation %d: stored picture does not decode: %v", tt.orientation, err)
+		}
+		if size := got.Bounds().Size(); size != tt.size {
+			t.Errorf("orientation %d: got size %v, want %v", tt.orientation, size, tt.size)
+		}
+		if c := color.NRGBAModel.Convert(got.At(tt.red.X, tt.red.Y)).(color.NRGBA); c.R != 255 {
+			t.Errorf("orientation %d: expected red at %v, got %v", tt.orientation, tt.red, c)
+		}
+		if c := color.NRGBAModel.Convert(got.At(tt.blue.X, tt.blue.Y)).(color.NRGBA); c.B != 255 {
+			t.Errorf("orientation %d: expected blue at %v, got %v", tt.orientation, tt.blue, c)
+		}
+		if info := readExif(stored); info.Orientation != 1 || info.Camera != "Canon EOS 5D" {
+			t.Errorf("orientation %d: got EXIF %+v, want orientation 1 with the camera kept", tt.orientation, info)
+		}
+	}
+}
+
+func TestAutoOrientJPEG(t *testing.T) {
+	upload := withJPEGExif(encodeJPEG(t, testImage(40, 30, func(x, y float64) float64 { return x })), orientationTIFF(6))
+
+	got, _, err := decodeImage(autoOrient(upload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size := got.Bounds().Size(); size != image.Pt(30, 40) {
+		t.Errorf("got size %v, want 30x40", size)
+	}
+
+	// Disabled by default: the upload is stored as sent.
+	if !bytes.Equal(prepareCatPic(upload), upload) {
+		t.Errorf("picture was re-oriented although auto-orient is disabled")
+	}
+}
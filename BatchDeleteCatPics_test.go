@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestBatchDeleteCatPics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"id1", "id2"} {
+		if err := insertCatPic(db, id, []byte("test data")); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	handler := BatchDeleteCatPics(db)
+
+	t.Run("Mixed existing and missing IDs", func(t *testing.T) {
+		body, _ := json.Marshal(BatchDeleteRequest{IDs: []string{"id1", "missing", "id2"}})
+		req, err := http.NewRequest("POST", "/catpics:batchDelete", bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		var resp BatchDeleteResponse
+		if err := json.NewDecoder(rr.Body).Decode(&resp); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if !reflect.DeepEqual(resp.Deleted, []string{"id1", "id2"}) {
+			t.Errorf("unexpected deleted IDs: %v", resp.Deleted)
+		}
+		if !reflect.DeepEqual(resp.NotFound, []string{"missing"}) {
+			t.Errorf("unexpected not found IDs: %v", resp.NotFound)
+		}
+
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM cat_pics WHERE deleted_at IS NULL").Scan(&count); err != nil {
+			t.Fatal("Failed to query database:", err)
+		}
+		if count != 0 {
+			t.Errorf("expected all records to be deleted, %d remain", count)
+		}
+	})
+
+	t.Run("Empty ID list", func(t *testing.T) {
+		req, err := http.NewRequest("POST", "/catpics:batchDelete", bytes.NewBufferString(`{"ids": []}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
@@ -3,10 +3,13 @@ package main
 import (
 	"bytes"
 	"database/sql"
+	"encoding/json"
 	"io"
 	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"sync"
 	"testing"
 
 	_ "github.com/mattn/go-sqlite3"
@@ -39,9 +42,9 @@ func setupDB(t *testing.T) *sql.DB {
 	if err != nil {
 		t.Fatalf("Unable to open sqlite database: %v", err)
 	}
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS cat_pics (id TEXT PRIMARY KEY, data BLOB NOT NULL);")
-	if err != nil {
-		t.Fatalf("Unable to create table: %v", err)
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		t.Fatalf("Unable to migrate database: %v", err)
 	}
 	return db
 }
@@ -110,4 +113,109 @@ func TestCreateCatPic(t *testing.T) {
             t.Errorf("Handler returned wrong status code for invalid file: got %v want %v", status, http.StatusBadRequest)
         }
     })
+
+	t.Run("Empty file", func(t *testing.T) {
+		// Only archive imports reject empty entries.
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		writer.CreateFormFile("catpic", "empty.jpg")
+		writer.Close()
+		req, _ := http.NewRequest("POST", "/catpics", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != http.StatusCreated {
+			t.Errorf("Handler returned wrong status code for an empty file: got %v want %v", status, http.StatusCreated)
+		}
+	})
+}
+
+func TestCreateCatPicDeduplication(t *testing.T) {
+	defer func(mode string) { config.DedupMode = mode }(config.DedupMode)
+
+	upload := func(t *testing.T, handler http.Handler) (int, string) {
+		req, err := createMultipartRequest("/catpics", "catpic", "cat.jpg")
+		if err != nil {
+			t.Fatalf("Failed to create request: %v", err)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		var resp CatPicResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return rr.Code, resp.ID
+	}
+
+	t.Run("Reference counted blob", func(t *testing.T) {
+		config.DedupMode = dedupRefCount
+		db := setupDB(t)
+		defer db.Close()
+		handler := CreateCatPic(db)
+
+		_, first := upload(t, handler)
+		status, second := upload(t, handler)
+		if status != http.StatusCreated || second == first {
+			t.Errorf("duplicate upload got status %v and ID %q, want a new picture", status, second)
+		}
+
+		var blobs, refs int
+		if err := db.QueryRow("SELECT COUNT(*), SUM(ref_count) FROM blobs").Scan(&blobs, &refs); err != nil {
+			t.Fatal(err)
+		}
+		if blobs != 1 || refs != 2 {
+			t.Errorf("got %d blobs with %d references, want 1 blob with 2 references", blobs, refs)
+		}
+	})
+
+	t.Run("Reuse existing picture", func(t *testing.T) {
+		config.DedupMode = dedupReuse
+		db := setupDB(t)
+		defer db.Close()
+		handler := CreateCatPic(db)
+
+		_, first := upload(t, handler)
+		status, second := upload(t, handler)
+		if status != http.StatusOK || second != first {
+			t.Errorf("duplicate upload got status %v and ID %q, want %v and %q", status, second, http.StatusOK, first)
+		}
+	})
+	t.Run("Concurrent identical uploads", func(t *testing.T) {
+		config.DedupMode = dedupReuse
+		db, err := openDB(filepath.Join(t.TempDir(), "catpics.sqlite3"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		handler := CreateCatPic(db)
+
+		var wg sync.WaitGroup
+		statuses := make([]int, 32)
+		for i := range statuses {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				statuses[i], _ = upload(t, handler)
+			}(i)
+		}
+		wg.Wait()
+
+		var created int
+		for _, status := range statuses {
+			switch status {
+			case http.StatusCreated:
+				created++
+			case http.StatusOK:
+			default:
+				t.Errorf("upload got status %v", status)
+			}
+		}
+		var pics int
+		if err := db.QueryRow("SELECT COUNT(*) FROM cat_pics").Scan(&pics); err != nil {
+			t.Fatal(err)
+		}
+		if created != 1 || pics != 1 {
+			t.Errorf("%d uploads created %d pictures, want 1", created, pics)
+		}
+	})
 }
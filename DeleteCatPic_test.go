@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
@@ -16,9 +17,9 @@ func setupTestDB(t *testing.T) *sql.DB {
 		t.Fatal("Failed to open sqlite database:", err)
 	}
 
-	_, err = db.Exec("CREATE TABLE IF NOT EXISTS cat_pics (id TEXT PRIMARY KEY, data BLOB NOT NULL);")
-	if err != nil {
-		t.Fatal("Failed to create table:", err)
+	db.SetMaxOpenConns(1)
+	if err := migrate(db); err != nil {
+		t.Fatal("Failed to migrate database:", err)
 	}
 
 	return db
@@ -29,7 +30,7 @@ func TestDeleteCatPic(t *testing.T) {
 	defer db.Close()
 
 	testID := "test-cat-pic-id"
-	_, err := db.Exec("INSERT INTO cat_pics (id, data) VALUES (?, ?)", testID, []byte("test data"))
+	err := insertCatPic(db, testID, []byte("test data"))
 	if err != nil {
 		t.Fatalf("Failed to insert test record: %v", err)
 	}
@@ -50,12 +51,69 @@ func TestDeleteCatPic(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v", status, http.StatusNoContent)
 	}
 
-	var count int
-	err = db.QueryRow("SELECT COUNT(*) FROM cat_pics WHERE id = ?", testID).Scan(&count)
+	var deletedAt sql.NullString
+	err = db.QueryRow("SELECT deleted_at FROM cat_pics WHERE id = ?", testID).Scan(&deletedAt)
 	if err != nil {
 		t.Fatal("Failed to query database:", err)
 	}
-	if count != 0 {
-		t.Errorf("Record was not deleted from the database")
+	if !deletedAt.Valid {
+		t.Errorf("Record was not moved to the trash")
+	}
+
+	rr = httptest.NewRecorder()
+	handler.ServeHTTP(rr, req)
+	if status := rr.Code; status != http.StatusNotFound {
+		t.Errorf("deleting a trashed picture returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestDeleteCatPicSharedBlob(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	data := []byte("shared cat pic")
+	for _, id := range []string{"first", "second"} {
+		if err := insertCatPic(db, id, data); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	handler := DeleteCatPic(db)
+	blobCount := func() int {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM blobs WHERE hash = ?", contentHash(data)).Scan(&count); err != nil {
+			t.Fatal("Failed to query database:", err)
+		}
+		return count
+	}
+
+	for _, id := range []string{"first", "second"} {
+		req, err := http.NewRequest("DELETE", "/catpics/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req = mux.SetURLVars(req, map[string]string{"id": id})
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		if rr.Code != http.StatusNoContent {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+	}
+
+	// Trashed pictures keep their bytes until they are purged.
+	if blobCount() != 1 {
+		t.Errorf("after trashing both pictures got %d blobs, want 1", blobCount())
+	}
+	if _, err := db.Exec("UPDATE cat_pics SET deleted_at = '2000-01-01T00:00:00Z' WHERE id = 'first'"); err != nil {
+		t.Fatal(err)
+	}
+	for i, cutoff := range []time.Time{time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), time.Now()} {
+		if _, err := purgeTrash(db, cutoff); err != nil {
+			t.Fatal(err)
+		}
+		if want := 1 - i; blobCount() != want {
+			t.Errorf("after purge %d got %d blobs, want %d", i+1, blobCount(), want)
+		}
 	}
 }
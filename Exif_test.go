@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testIFDEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func asciiEntry(tag uint16, s string) testIFDEntry {
+	return testIFDEntry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+}
+
+func shortEntry(tag uint16, v uint16) testIFDEntry {
+	return testIFDEntry{tag: tag, typ: 3, count: 1, value: binary.LittleEndian.AppendUint16(nil, v)}
+}
+
+func longEntry(tag uint16, v uint32) testIFDEntry {
+	return testIFDEntry{tag: tag, typ: 4, count: 1, value: binary.LittleEndian.AppendUint32(nil, v)}
+}
+
+func testIFDSize(entries []testIFDEntry) int {
+	size := 2 + 12*len(entries) + 4
+	for _, e := range entries {
+		if len(e.value) > 4 {
+			size += len(e.value) + len(e.value)%2
+		}
+	}
+	return size
+}
+
+// buildTestTIFF lays out IFD0, the Exif IFD and the GPS IFD one after the
+// other in little-endian TIFF, adding the sub-IFD pointers to IFD0.
+func buildTestTIFF(ifd0, exifIFD, gpsIFD []testIFDEntry) []byte {
+	if exifIFD != nil {
+		ifd0 = append(ifd0, longEntry(tagExifIFD, 0))
+	}
+	if gpsIFD != nil {
+		ifd0 = append(ifd0, longEntry(tagGPSIFD, 0))
+	}
+	exifOff := uint32(8 + testIFDSize(ifd0))
+	gpsOff := exifOff + uint32(testIFDSize(exifIFD))
+	for i := range ifd0 {
+		switch ifd0[i].tag {
+		case tagExifIFD:
+			ifd0[i] = longEntry(tagExifIFD, exifOff)
+		case tagGPSIFD:
+			ifd0[i] = longEntry(tagGPSIFD, gpsOff)
+		}
+	}
+
+	b := []byte("II*\x00\x08\x00\x00\x00")
+	for _, entries := range [][]testIFDEntry{ifd0, exifIFD, gpsIFD} {
+		if entries == nil {
+			continue
+		}
+		sort.Slice(entries, func(i, j int) bool { return entries[i].tag < entries[j].tag })
+
+		dataOff := uint32(len(b) + 2 + 12*len(entries) + 4)
+		var data []byte
+		b = binary.LittleEndian.AppendUint16(b, uint16(len(entries)))
+		for _, e := range entries {
+			b = binary.LittleEndian.AppendUint16(b, e.tag)
+			b = binary.LittleEndian.AppendUint16(b, e.typ)
+			b = binary.LittleEndian.AppendUint32(b, e.count)
+			if len(e.value) <= 4 {
+				b = append(b, make([]byte, 4)...)
+				copy(b[len(b)-4:], e.value)
+				continue
+			}
+			b = binary.LittleEndian.AppendUint32(b, dataOff+uint32(len(data)))
+			data = append(data, e.value...)
+			if len(e.value)%2 == 1 {
+				data = append(data, 0)
+			}
+		}
+		b = binary.LittleEndian.AppendUint32(b, 0)
+		b = append(b, data...)
+	}
+	return b
+}
+
+// withJPEGExif inserts tiff as an APP1 segment right after the JPEG SOI marker.
+func withJPEGExif(jpegData, tiff []byte) []byte {
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	seg = append(seg, payload...)
+	return append(append(append([]byte(nil), jpegData[:2]...), seg...), jpegData[2:]...)
+}
+
+// withPNGExif inserts tiff as an eXIf chunk right after the PNG IHDR chunk.
+func withPNGExif(pngData, tiff []byte) []byte {
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+	chunk = append(chunk, "eXIf"...)
+	chunk = append(chunk, tiff...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(pngData[8:]))
+	return append(append(append([]byte(nil), pngData[:ihdrEnd]...), chunk...), pngData[ihdrEnd:]...)
+}
+
+func testExifTIFF() []byte {
+	return buildTestTIFF(
+		[]testIFDEntry{
+			asciiEntry(tagMake, "Canon"),
+			asciiEntry(tagModel, "EOS 5D"),
+			shortEntry(tagOrientation, 6),
+			asciiEntry(tagArtist, "Jane Doe"),
+		},
+		[]testIFDEntry{
+			asciiEntry(tagDateTimeOriginal, "2024:03:01 10:20:30"),
+			asciiEntry(tagOffsetTimeOrig, "+01:00"),
+			asciiEntry(tagBodySerialNumber, "SN12345678"),
+		},
+		[]testIFDEntry{
+			asciiEntry(0x0001, "N"),
+			{tag: 0x0002, typ: 5, count: 3, value: []byte("LATITUDE-SECRET-01234567")},
+		},
+	)
+}
+
+func TestExifExtractionAndStripping(t *testing.T) {
+	img := testImage(40, 30, func(x, y float64) float64 { return x })
+	fixtures := map[string][]byte{
+		"JPEG": withJPEGExif(encodeJPEG(t, img), testExifTIFF()),
+		"PNG":  withPNGExif(encodePNG(t, img), testExifTIFF()),
+	}
+
+	for name, upload := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			db := setupTestDB(t)
+			defer db.Close()
+
+			body := new(bytes.Buffer)
+			writer := multipart.NewWriter(body)
+			part, _ := writer.CreateFormFile("catpic", "cat")
+			part.Write(upload)
+			writer.Close()
+
+			req, _ := http.NewRequest("POST", "/catpics", body)
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+			rr := httptest.NewRecorder()
+			CreateCatPic(db).ServeHTTP(rr, req)
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+			}
+			var created CatPicResponse
+			json.NewDecoder(rr.Body).Decode(&created)
+
+			stored, err := loadCatPicData(db, created.ID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, secret := range []string{"LATITUDE-SECRET", "SN12345678", "Jane Doe"} {
+				if bytes.Contains(stored, []byte(secret)) {
+					t.Errorf("stored picture still contains %q", secret)
+				}
+			}
+			if _, _, err := decodeImage(stored); err != nil {
+				t.Errorf("stored picture no longer decodes: %v", err)
+			}
+			if name == "PNG" {
+				if _, err := png.Decode(bytes.NewReader(stored)); err != nil {
+					t.Errorf("stored PNG fails checksum verification: %v", err)
+				}
+			}
+
+			r := mux.NewRouter()
+			r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+			listReq, _ := http.NewRequest("GET", "/catpics?ids="+created.ID, nil)
+			rr = httptest.NewRecorder()
+			r.ServeHTTP(rr, listReq)
+
+			var pics []CatPicResponse
+			json.NewDecoder(rr.Body).Decode(&pics)
+			if len(pics) != 1 || pics[0].Camera != "Canon EOS 5D" || pics[0].TakenAt != "2024-03-01T10:20:30+01:00" || pics[0].Orientation != 6 {
+				t.Errorf("got metadata %+v, want camera Canon EOS 5D taken 2024-03-01T10:20:30+01:00 with orientation 6", pics)
+			}
+		})
+	}
+}
+
+// withPNGXMP inserts an iTXt chunk holding an XMP packet after the IHDR chunk.
+func withPNGXMP(pngData []byte, xmp string) []byte {
+	text := append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), xmp...)
+	chunk := binary.BigEndian.AppendUint32(nil, uint32(len(text)))
+	chunk = append(chunk, "iTXt"...)
+	chunk = append(chunk, text...)
+	chunk = binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+
+	ihdrEnd := 8 + 12 + int(binary.BigEndian.Uint32(pngData[8:]))
+	return append(append(append([]byte(nil), pngData[:ihdrEnd]...), chunk...), pngData[ihdrEnd:]...)
+}
+
+// withJPEGXMP inserts an APP1 segment holding an XMP packet after the SOI marker.
+func withJPEGXMP(jpegData []byte, xmp string) []byte {
+	payload := append([]byte("http://ns.adobe.com/xap/1.0/\x00"), xmp...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	seg = append(seg, payload...)
+	return append(append(append([]byte(nil), jpegData[:2]...), seg...), jpegData[2:]...)
+}
+
+const testXMP = `<x:xmpmeta xmlns:x="adobe:ns:meta/"><exif:GPSLatitude>XMP-LATITUDE</exif:GPSLatitude></x:xmpmeta>`
+
+func TestExifStrippingCoversEveryBlock(t *testing.T) {
+	img := testImage(40, 30, func(x, y float64) float64 { return x })
+	// Writers may repeat the EXIF block, and XMP duplicates its GPS fields.
+	fixtures := map[string][]byte{
+		"JPEG": withJPEGXMP(withJPEGExif(withJPEGExif(encodeJPEG(t, img), testExifTIFF()), testExifTIFF()), testXMP),
+		"PNG":  withPNGXMP(withPNGExif(withPNGExif(encodePNG(t, img), testExifTIFF()), testExifTIFF()), testXMP),
+	}
+
+	for name, upload := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			stripped := stripSensitiveExif(upload)
+			for _, secret := range []string{"LATITUDE-SECRET", "SN12345678", "Jane Doe", "XMP-LATITUDE"} {
+				if bytes.Contains(stripped, []byte(secret)) {
+					t.Errorf("stripped picture still contains %q", secret)
+				}
+			}
+			if !bytes.Contains(stripped, []byte("EOS 5D")) {
+				t.Errorf("stripped picture lost its camera model")
+			}
+			if _, _, err := decodeImage(stripped); err != nil {
+				t.Errorf("stripped picture no longer decodes: %v", err)
+			}
+			if name == "PNG" {
+				if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+					t.Errorf("stripped PNG fails checksum verification: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestExifStrippedWhenServed(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Stored as it was before stripping covered it, bypassing prepareCatPic.
+	upload := withJPEGXMP(withJPEGExif(encodeJPEG(t, testImage(40, 30, func(x, y float64) float64 { return y })), testExifTIFF()), testXMP)
+	if err := withTx(context.Background(), db, func(tx *sql.Tx) error { return insertCatPic(tx, "old", upload) }); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/export.zip", ExportCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}", GetCatPicVersion(db)).Methods("GET")
+	for _, path := range []string{"/catpics/old", "/catpics/old/versions/1", "/catpics/export.zip"} {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("GET %s returned wrong status code: got %v want %v", path, rr.Code, http.StatusOK)
+		}
+		for _, secret := range []string{"LATITUDE-SECRET", "SN12345678", "XMP-LATITUDE"} {
+			if bytes.Contains(rr.Body.Bytes(), []byte(secret)) {
+				t.Errorf("GET %s still serves %q", path, secret)
+			}
+		}
+	}
+}
+
+func TestExifStrippingDisabled(t *testing.T) {
+	defer func(strip bool) { config.StripSensitiveExif = strip }(config.StripSensitiveExif)
+	config.StripSensitiveExif = false
+
+	upload := withJPEGExif(encodeJPEG(t, testImage(40, 30, func(x, y float64) float64 { return y })), testExifTIFF())
+	if got := prepareCatPic(upload); !bytes.Equal(got, upload) {
+		t.Errorf("picture was modified although stripping is disabled")
+	}
+}
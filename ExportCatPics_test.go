@@ -0,0 +1,89 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestExportCatPics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Inserted out of order, as the archive lists pictures by ID.
+	for _, id := range []string{"id2", "id1"} {
+		if err := insertCatPic(db, id, []byte("test data "+id)); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	tt := []struct {
+		name      string
+		url       string
+		wantFiles []string
+	}{
+		{name: "All Pictures", url: "/catpics/export.zip", wantFiles: []string{"id1.bin", "id2.bin", exportManifestName}},
+		{name: "Filtered Pictures", url: "/catpics/export.zip?ids=id2", wantFiles: []string{"id2.bin", exportManifestName}},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			ExportCatPics(db).ServeHTTP(rr, req)
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			zr, err := zip.NewReader(bytes.NewReader(rr.Body.Bytes()), int64(rr.Body.Len()))
+			if err != nil {
+				t.Fatalf("Failed to read archive: %v", err)
+			}
+
+			if len(zr.File) != len(tc.wantFiles) {
+				t.Fatalf("archive has %d entries, want %d", len(zr.File), len(tc.wantFiles))
+			}
+			for i, f := range zr.File {
+				if f.Name != tc.wantFiles[i] {
+					t.Errorf("entry %d is %q, want %q", i, f.Name, tc.wantFiles[i])
+				}
+			}
+
+			mf, err := zr.File[len(zr.File)-1].Open()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer mf.Close()
+			raw, _ := io.ReadAll(mf)
+
+			var manifest ExportManifest
+			if err := json.Unmarshal(raw, &manifest); err != nil {
+				t.Fatalf("Failed to decode manifest: %v", err)
+			}
+			if len(manifest.Pictures) != len(tc.wantFiles)-1 {
+				t.Errorf("manifest lists %d pictures, want %d", len(manifest.Pictures), len(tc.wantFiles)-1)
+			}
+		})
+	}
+
+	t.Run("Too Many IDs", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/catpics/export.zip?ids=id1"+strings.Repeat(",id2", maxFilterIDs), nil)
+		rr := httptest.NewRecorder()
+		ExportCatPics(db).ServeHTTP(rr, req)
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
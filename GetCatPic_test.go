@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"net/http"
 	"net/http/httptest"
 	"testing"
@@ -14,7 +15,7 @@ func TestMain(t *testing.T) {
 	defer db.Close()
 
 	testID := "test-get-id"
-	_, err := db.Exec("INSERT INTO cat_pics (id, data) VALUES (?, ?)", testID, []byte("test cat pic data"))
+	err := insertCatPic(db, testID, []byte("test cat pic data"))
 	if err != nil {
 		t.Fatalf("Failed to insert test record: %v", err)
 	}
@@ -51,3 +52,71 @@ func TestMain(t *testing.T) {
 		})
 	}
 }
+
+func TestGetCatPicConversion(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	stored := encodePNG(t, testImage(40, 30, func(x, y float64) float64 { return x }))
+	if err := insertCatPic(db, "png-id", stored); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+	if err := insertCatPic(db, "text-id", []byte("test cat pic data")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+
+	tt := []struct {
+		name            string
+		url             string
+		accept          string
+		wantStatus      int
+		wantContentType string
+	}{
+		{name: "No Accept Header", url: "/catpics/png-id", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "Browser Accept Header", url: "/catpics/png-id", accept: "image/avif,image/webp,image/*,*/*;q=0.8", wantStatus: http.StatusOK, wantContentType: "image/png"},
+		{name: "Accept JPEG Only", url: "/catpics/png-id", accept: "image/jpeg", wantStatus: http.StatusOK, wantContentType: "image/jpeg"},
+		{name: "Accept Prefers GIF", url: "/catpics/png-id", accept: "image/png;q=0.5, image/gif", wantStatus: http.StatusOK, wantContentType: "image/gif"},
+		{name: "Format Overrides Accept", url: "/catpics/png-id?format=jpeg&quality=50", accept: "image/png", wantStatus: http.StatusOK, wantContentType: "image/jpeg"},
+		{name: "Not Acceptable", url: "/catpics/png-id", accept: "image/webp, */*;q=0", wantStatus: http.StatusNotAcceptable},
+		{name: "Invalid Format", url: "/catpics/png-id?format=tiff", wantStatus: http.StatusBadRequest},
+		{name: "Invalid Quality", url: "/catpics/png-id?format=jpeg&quality=0", wantStatus: http.StatusBadRequest},
+		{name: "Undecodable Picture", url: "/catpics/text-id?format=png", wantStatus: http.StatusUnprocessableEntity},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tc.accept != "" {
+				req.Header.Set("Accept", tc.accept)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if rr.Header().Get("Vary") != "Accept" {
+				t.Errorf("missing Vary: Accept header")
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rr.Header().Get("Content-Type"); got != tc.wantContentType {
+				t.Errorf("got Content-Type %q, want %q", got, tc.wantContentType)
+			}
+			if got := http.DetectContentType(rr.Body.Bytes()); got != tc.wantContentType {
+				t.Errorf("body is %q, want %q", got, tc.wantContentType)
+			}
+			if tc.wantContentType == "image/png" && !bytes.Equal(rr.Body.Bytes(), stored) {
+				t.Errorf("stored PNG was re-encoded")
+			}
+		})
+	}
+}
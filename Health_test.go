@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestHealthz(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/healthz", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(Healthz).ServeHTTP(rr, req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+}
+
+func TestReadyz(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	defer func(min int) { config.MinFreeDiskMB = min }(config.MinFreeDiskMB)
+	config.MinFreeDiskMB = 0
+
+	var shuttingDown atomic.Bool
+	handler := Readyz(db, t.TempDir(), &shuttingDown)
+	ready := func(wantCode int) HealthStatus {
+		t.Helper()
+		req, _ := http.NewRequest("GET", "/readyz", nil)
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		var status HealthStatus
+		if err := json.Unmarshal(rr.Body.Bytes(), &status); err != nil {
+			t.Fatal(err)
+		}
+		if rr.Code != wantCode {
+			t.Errorf("handler returned wrong status code: got %v want %v (%+v)", rr.Code, wantCode, status)
+		}
+		return status
+	}
+
+	status := ready(http.StatusOK)
+	for _, name := range []string{"database", "migrations", "storage", "disk"} {
+		if check, ok := status.Checks[name]; !ok || check.Status == healthFailing {
+			t.Errorf("check %q is %+v, want it to pass", name, check)
+		}
+	}
+	var blobs int
+	db.QueryRow("SELECT COUNT(*) FROM blobs").Scan(&blobs)
+	if blobs != 0 {
+		t.Errorf("the storage check left %d blobs behind", blobs)
+	}
+
+	config.MinFreeDiskMB = 1 << 40
+	if status := ready(http.StatusServiceUnavailable); status.Checks["disk"].Status != healthFailing {
+		t.Errorf("disk check is %+v, want it to fail", status.Checks["disk"])
+	}
+	config.MinFreeDiskMB = 0
+
+	if _, err := db.Exec("PRAGMA user_version = 1"); err != nil {
+		t.Fatal(err)
+	}
+	if status := ready(http.StatusServiceUnavailable); status.Checks["migrations"].Status != healthFailing {
+		t.Errorf("migrations check is %+v, want it to fail", status.Checks["migrations"])
+	}
+	if _, err := db.Exec(fmt.Sprintf("PRAGMA user_version = %d", len(migrations))); err != nil {
+		t.Fatal(err)
+	}
+
+	shuttingDown.Store(true)
+	if status := ready(http.StatusServiceUnavailable); status.Checks["shutdown"].Status != healthFailing {
+		t.Errorf("shutdown check is %+v, want it to fail", status.Checks["shutdown"])
+	}
+}
+
+func TestServeShutsDown(t *testing.T) {
+	defer func(delay time.Duration) { config.ShutdownDelay = delay }(config.ShutdownDelay)
+	config.ShutdownDelay = 0
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var shuttingDown atomic.Bool
+	result := make(chan error, 1)
+	go func() {
+		result <- serve(ctx, &http.Server{Addr: "127.0.0.1:0", Handler: http.NotFoundHandler()}, &shuttingDown)
+	}()
+	cancel()
+
+	select {
+	case err := <-result:
+		if err != nil {
+			t.Errorf("serve returned %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("serve did not return after its context was cancelled")
+	}
+	if !shuttingDown.Load() {
+		t.Error("readiness was not flipped during shutdown")
+	}
+}
+
+func TestReadyzDuringWrite(t *testing.T) {
+	db, err := openDB(filepath.Join(t.TempDir(), "catpics.sqlite3"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// Hold the write lock the way a long import does.
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback()
+	if _, err := tx.Exec("INSERT INTO blobs (hash, data, ref_count) VALUES ('pending', x'', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	if check := checkStorage(context.Background(), db); check.Status != healthOK {
+		t.Errorf("storage check is %+v while a write is in progress, want it to pass", check)
+	}
+	if elapsed := time.Since(start); elapsed > readinessTimeout {
+		t.Errorf("storage check took %v while a write is in progress", elapsed)
+	}
+}
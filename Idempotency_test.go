@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCreateCatPicIdempotencyKey(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+	handler := CreateCatPic(db)
+
+	upload := func(key, data string, tags ...string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.txt")
+		part.Write([]byte(data))
+		for _, tag := range tags {
+			writer.WriteField("tags", tag)
+		}
+		writer.Close()
+		req, _ := http.NewRequest("POST", "/v1/catpics", body)
+		req = req.WithContext(context.WithValue(req.Context(), apiVersionKey{}, 1))
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if key != "" {
+			req.Header.Set("Idempotency-Key", key)
+		}
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+	count := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM cat_pics").Scan(&n)
+		return n
+	}
+
+	first := upload("key-1", "fake cat pic content", "cute,fluffy")
+	if first.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", first.Code, http.StatusCreated)
+	}
+
+	retry := upload("key-1", "fake cat pic content", "fluffy", "cute")
+	if retry.Code != http.StatusCreated {
+		t.Errorf("retry returned wrong status code: got %v want %v", retry.Code, http.StatusCreated)
+	}
+	if retry.Body.String() != first.Body.String() {
+		t.Errorf("retry returned %q, want the original %q", retry.Body.String(), first.Body.String())
+	}
+	if retry.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry is not marked as replayed")
+	}
+	for _, name := range []string{"Location", "ETag"} {
+		if got, want := retry.Header().Get(name), first.Header().Get(name); got == "" || got != want {
+			t.Errorf("retry has %s %q, want the original %q", name, got, want)
+		}
+	}
+	if n := count(); n != 1 {
+		t.Errorf("got %d pictures after a retry, want 1", n)
+	}
+	if rr := upload("key-1", "fake cat pic content", "Fluffy, CUTE", "cute"); rr.Header().Get("Idempotent-Replayed") != "true" {
+		t.Errorf("retry with differently written tags: got status %v, want a replay", rr.Code)
+	}
+
+	if rr := upload("key-1", "another cat pic"); rr.Code != http.StatusUnprocessableEntity {
+		t.Errorf("reused key: got status %v want %v", rr.Code, http.StatusUnprocessableEntity)
+	}
+	if rr := upload("key-2", "fake cat pic content"); rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+		t.Errorf("new key: got status %v, want a fresh %v", rr.Code, http.StatusCreated)
+	}
+	if rr := upload("", "fake cat pic content"); rr.Code != http.StatusCreated {
+		t.Errorf("no key: got status %v want %v", rr.Code, http.StatusCreated)
+	}
+	if n := count(); n != 3 {
+		t.Errorf("got %d pictures, want 3", n)
+	}
+
+	t.Run("Expired", func(t *testing.T) {
+		past := time.Now().Add(-config.IdempotencyTTL - time.Minute).UTC().Format(time.RFC3339)
+		if _, err := db.Exec("UPDATE idempotency_keys SET created_at = ? WHERE key = 'key-1'", past); err != nil {
+			t.Fatal(err)
+		}
+
+		rr := upload("key-1", "another cat pic")
+		if rr.Code != http.StatusCreated || rr.Header().Get("Idempotent-Replayed") != "" {
+			t.Errorf("expired key: got status %v, want a fresh %v", rr.Code, http.StatusCreated)
+		}
+		if replay := upload("key-1", "another cat pic"); replay.Body.String() == first.Body.String() {
+			t.Errorf("expired key still replays the original response")
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		resp, err := newIdempotentResponse("fingerprint", http.StatusCreated, nil, map[string]string{"id": "a"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := saveIdempotencyKey(db, "key-3", resp, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if err := saveIdempotencyKey(db, "key-3", resp, time.Now()); err != errIdempotencyKeyInUse {
+			t.Errorf("got %v saving a key twice, want errIdempotencyKeyInUse", err)
+		}
+	})
+
+	if rr := upload(strings.Repeat("k", maxIdempotencyKeyLength+1), "fake cat pic content"); rr.Code != http.StatusBadRequest {
+		t.Errorf("long key: got status %v want %v", rr.Code, http.StatusBadRequest)
+	}
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// pngWithSize returns a small PNG whose header claims the given dimensions,
+// like a decompression bomb would.
+func pngWithSize(t *testing.T, width, height uint32) []byte {
+	data := encodePNG(t, image.NewGray(image.Rect(0, 0, 8, 8)))
+	binary.BigEndian.PutUint32(data[16:], width)
+	binary.BigEndian.PutUint32(data[20:], height)
+	binary.BigEndian.PutUint32(data[29:], crc32.ChecksumIEEE(data[12:29]))
+	return data
+}
+
+func TestImageDimensionLimits(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	bomb := pngWithSize(t, 50000, 50000)
+	if err := insertCatPic(db, "bomb-id", bomb); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}/transform", TransformCatPic(db)).Methods("GET")
+
+	upload := func(method, url string, data []byte) *http.Request {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.png")
+		part.Write(data)
+		writer.Close()
+		req, _ := http.NewRequest(method, url, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		return req
+	}
+	get := func(url string) *http.Request {
+		req, _ := http.NewRequest("GET", url, nil)
+		return req
+	}
+
+	tt := []struct {
+		name string
+		req  *http.Request
+	}{
+		{name: "Create", req: upload("POST", "/catpics", bomb)},
+		{name: "Update", req: upload("PUT", "/catpics/bomb-id", bomb)},
+		{name: "Convert", req: get("/catpics/bomb-id?format=jpeg")},
+		{name: "Transform", req: get("/catpics/bomb-id/transform?ops=grayscale")},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, tc.req)
+			if rr.Code != http.StatusUnprocessableEntity {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusUnprocessableEntity)
+			}
+			if !strings.Contains(rr.Body.String(), "50000x50000") {
+				t.Errorf("error does not mention the dimensions: %s", rr.Body)
+			}
+		})
+	}
+
+	t.Run("Configurable", func(t *testing.T) {
+		defer func(c Config) { config = c }(config)
+		config.MaxImageWidth, config.MaxImageHeight, config.MaxImagePixels = 100, 100, 5000
+
+		for _, tc := range []struct {
+			width, height uint32
+			ok            bool
+		}{
+			{100, 50, true},
+			{101, 10, false},
+			{10, 101, false},
+			{80, 80, false},
+		} {
+			err := validateCatPic(pngWithSize(t, tc.width, tc.height))
+			if (err == nil) != tc.ok {
+				t.Errorf("%dx%d: got error %v, want ok=%v", tc.width, tc.height, err, tc.ok)
+			}
+		}
+	})
+}
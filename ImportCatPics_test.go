@@ -0,0 +1,143 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func createImportRequest(t *testing.T, archive []byte) *http.Request {
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, err := writer.CreateFormFile("archive", "catpics.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(archive)
+	writer.Close()
+
+	req, err := http.NewRequest("POST", "/catpics/import", body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	return req
+}
+
+func TestImportCatPics(t *testing.T) {
+	keptID := uuid.NewString()
+	files := map[string][]byte{
+		keptID + ".jpg":    []byte("exported cat pic"),
+		"my-cat_1.jpg":     []byte("client cat pic"),
+		"new cat.jpg":      []byte("new cat pic"),
+		"empty.jpg":        {},
+		exportManifestName: []byte(`{"pictures": []}`),
+	}
+
+	var zipBuf bytes.Buffer
+	zw := zip.NewWriter(&zipBuf)
+	for name, data := range files {
+		fw, _ := zw.Create(name)
+		fw.Write(data)
+	}
+	zw.Close()
+
+	var tgzBuf bytes.Buffer
+	gw := gzip.NewWriter(&tgzBuf)
+	tw := tar.NewWriter(gw)
+	for name, data := range files {
+		tw.WriteHeader(&tar.Header{Name: name, Mode: 0o644, Size: int64(len(data)), Typeflag: tar.TypeReg})
+		tw.Write(data)
+	}
+	tw.Close()
+	gw.Close()
+
+	tt := []struct {
+		name    string
+		archive []byte
+	}{
+		{name: "ZIP Archive", archive: zipBuf.Bytes()},
+		{name: "Tar Gzip Archive", archive: tgzBuf.Bytes()},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			db := setupTestDB(t)
+			defer db.Close()
+
+			rr := httptest.NewRecorder()
+			ImportCatPics(db).ServeHTTP(rr, createImportRequest(t, tc.archive))
+
+			if rr.Code != http.StatusOK {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+			}
+
+			var report ImportReport
+			if err := json.NewDecoder(rr.Body).Decode(&report); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(report.Imported) != 3 {
+				t.Errorf("imported %d pictures, want 3", len(report.Imported))
+			}
+			if len(report.Failed) != 1 || report.Failed[0].File != "empty.jpg" {
+				t.Errorf("unexpected failures: %+v", report.Failed)
+			}
+
+			for _, id := range []string{keptID, "my-cat_1"} {
+				if _, err := loadCatPicData(db, id); err != nil {
+					t.Errorf("exported ID %s was not preserved: %v", id, err)
+				}
+			}
+		})
+	}
+
+	t.Run("Reimported Export", func(t *testing.T) {
+		db := setupTestDB(t)
+		defer db.Close()
+
+		id := uuid.NewString()
+		if err := insertCatPic(db, id, []byte("exported cat pic")); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+		req, _ := http.NewRequest("GET", "/catpics/export.zip", nil)
+		export := httptest.NewRecorder()
+		ExportCatPics(db).ServeHTTP(export, req)
+
+		rr := httptest.NewRecorder()
+		ImportCatPics(db).ServeHTTP(rr, createImportRequest(t, export.Body.Bytes()))
+		var report ImportReport
+		json.NewDecoder(rr.Body).Decode(&report)
+		if rr.Code != http.StatusOK || len(report.Imported) != 0 || len(report.Failed) != 1 {
+			t.Fatalf("got status %v and report %+v, want the existing ID to fail", rr.Code, report)
+		}
+
+		var refs int
+		if err := db.QueryRow("SELECT ref_count FROM blobs").Scan(&refs); err != nil {
+			t.Fatal(err)
+		}
+		if refs != 1 {
+			t.Errorf("got ref_count %d after the failed import, want 1", refs)
+		}
+	})
+
+	t.Run("Unsupported Archive", func(t *testing.T) {
+		db := setupTestDB(t)
+		defer db.Close()
+
+		rr := httptest.NewRecorder()
+		ImportCatPics(db).ServeHTTP(rr, createImportRequest(t, []byte("not an archive")))
+
+		if rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
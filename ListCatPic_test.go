@@ -35,8 +35,8 @@ func TestListCatPics(t *testing.T) {
 		{
 			name: "Database With Records",
 			setupData: func(db *sql.DB) {
-				db.Exec("INSERT INTO cat_pics (id, data) VALUES (?, ?)", "id1", []byte("test data 1"))
-				db.Exec("INSERT INTO cat_pics (id, data) VALUES (?, ?)", "id2", []byte("test data 2"))
+				insertCatPic(db, "id1", []byte("test data 1"))
+				insertCatPic(db, "id2", []byte("test data 2"))
 			},
 			expectedCode: http.StatusOK,
 			expectedSize: 2,
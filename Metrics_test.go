@@ -0,0 +1,82 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"log/slog"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestMetrics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"cat-1", "cat-2", "cat-3"} {
+		if err := insertCatPic(db, id, []byte("shared cat pic data")); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+	if _, err := trashCatPic(db, "cat-3", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.Use(recordRoute)
+	r.Handle("/metrics", metricsHandler(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+	handler := accessLog(slog.New(slog.NewJSONHandler(io.Discard, nil)), r)
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req, _ := http.NewRequest("GET", "/catpics/metrics-test-missing", nil)
+	serve(req)
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("catpic", "cat.txt")
+	part.Write(bytes.Repeat([]byte("x"), 20<<10))
+	writer.Close()
+	req, _ = http.NewRequest("PUT", "/catpics/cat-1", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	if rr := serve(req); rr.Code != http.StatusOK {
+		t.Fatalf("update returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+
+	req, _ = http.NewRequest("GET", "/metrics", nil)
+	rr := serve(req)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain") {
+		t.Errorf("got Content-Type %q, want the text exposition format", ct)
+	}
+
+	metrics := rr.Body.String()
+	for _, want := range []string{
+		`catpics_http_requests_total{method="GET",route="/catpics/{id}",status="404"}`,
+		`catpics_http_request_duration_seconds_bucket{method="PUT",route="/catpics/{id}",status="200",le="+Inf"}`,
+		`catpics_upload_size_bytes_bucket{operation="update",le="65536"}`,
+		`catpics_pictures{state="live"} 2`,
+		`catpics_pictures{state="trashed"} 1`,
+		// The shared blob once, plus the upload.
+		`catpics_blob_bytes 20499`,
+		`go_sql_open_connections{db_name="catpics"}`,
+	} {
+		if !strings.Contains(metrics, want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+}
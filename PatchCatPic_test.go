@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestPatchCatPic(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	testID := "test-patch-id"
+	if err := insertCatPic(db, testID, []byte("test cat pic data")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", PatchCatPic(db)).Methods("PATCH")
+
+	patch := func(id, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("PATCH", "/catpics/"+id, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Partial Updates", func(t *testing.T) {
+		patch(testID, `{"title": "Nap time", "caption": "A kitten asleep on a keyboard"}`)
+		rr := patch(testID, `{"altText": "Grey kitten curled up on a laptop"}`)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		var pic CatPicResponse
+		if err := json.NewDecoder(rr.Body).Decode(&pic); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		want := CatPicResponse{ID: testID, Title: "Nap time", Caption: "A kitten asleep on a keyboard", AltText: "Grey kitten curled up on a laptop", Version: 1}
+		if !reflect.DeepEqual(pic, want) {
+			t.Errorf("got %+v, want %+v", pic, want)
+		}
+
+		data, err := loadCatPicData(db, testID)
+		if err != nil || string(data) != "test cat pic data" {
+			t.Errorf("image data changed: %q, %v", data, err)
+		}
+	})
+
+	t.Run("Too Long", func(t *testing.T) {
+		long, _ := json.Marshal(map[string]string{"title": string(make([]byte, maxTitleLength+1))})
+		if rr := patch(testID, string(long)); rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Missing Picture", func(t *testing.T) {
+		if rr := patch("missing", `{"title": "Ghost"}`); rr.Code != http.StatusNotFound {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestPlaceholders(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	// Three quarters red, one quarter blue.
+	img := image.NewRGBA(image.Rect(0, 0, 80, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 80; x++ {
+			c := color.RGBA{255, 0, 0, 255}
+			if x >= 60 {
+				c = color.RGBA{0, 0, 255, 255}
+			}
+			img.Set(x, y, c)
+		}
+	}
+	if err := insertCatPic(db, "text-id", []byte("test cat pic data")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+
+	upload := func(method, url string, data []byte) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.png")
+		part.Write(data)
+		writer.Close()
+		req, _ := http.NewRequest(method, url, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	list := func() map[string]CatPicResponse {
+		req, _ := http.NewRequest("GET", "/catpics", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		var pics []CatPicResponse
+		if err := json.NewDecoder(rr.Body).Decode(&pics); err != nil {
+			t.Fatal(err)
+		}
+		byID := map[string]CatPicResponse{}
+		for _, pic := range pics {
+			byID[pic.ID] = pic
+		}
+		return byID
+	}
+
+	rr := upload("POST", "/catpics", encodePNG(t, img))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created CatPicResponse
+	json.NewDecoder(rr.Body).Decode(&created)
+
+	pic := list()[created.ID]
+	if want := []string{"#ff0000", "#0000ff"}; !reflect.DeepEqual(pic.Palette, want) {
+		t.Errorf("got palette %v, want %v", pic.Palette, want)
+	}
+	// 4x3 components: a size flag, the maximum AC value, 4 characters of DC
+	// and 2 for each of the 11 AC components.
+	if len(pic.BlurHash) != 28 || pic.BlurHash[0] != 'L' {
+		t.Errorf("got BlurHash %q, want a 28 character hash starting with L", pic.BlurHash)
+	}
+	if undecodable := list()["text-id"]; undecodable.Palette != nil || undecodable.BlurHash != "" {
+		t.Errorf("got placeholders for an undecodable picture: %+v", undecodable)
+	}
+
+	t.Run("Update", func(t *testing.T) {
+		solid := image.NewRGBA(image.Rect(0, 0, 10, 10))
+		for y := 0; y < 10; y++ {
+			for x := 0; x < 10; x++ {
+				solid.Set(x, y, color.RGBA{0, 255, 0, 255})
+			}
+		}
+		if rr := upload("PUT", "/catpics/"+created.ID, encodePNG(t, solid)); rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		updated := list()[created.ID]
+		if !reflect.DeepEqual(updated.Palette, []string{"#00ff00"}) {
+			t.Errorf("got palette %v, want [#00ff00]", updated.Palette)
+		}
+		if updated.BlurHash == pic.BlurHash {
+			t.Errorf("BlurHash did not change with the picture")
+		}
+	})
+}
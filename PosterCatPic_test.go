@@ -0,0 +1,128 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/gif"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// encodeAnimatedGIF returns a 20x10 GIF whose frames are filled with the given
+// colours. Every frame after the first only covers the left half.
+func encodeAnimatedGIF(t *testing.T, loopCount int, delays []int, colors []color.Color) []byte {
+	anim := &gif.GIF{LoopCount: loopCount, Delay: delays}
+	for i, c := range colors {
+		bounds := image.Rect(0, 0, 20, 10)
+		if i > 0 {
+			bounds = image.Rect(0, 0, 10, 10)
+		}
+		frame := image.NewPaletted(bounds, palette.Plan9)
+		for j := range frame.Pix {
+			frame.Pix[j] = uint8(frame.Palette.Index(c))
+		}
+		anim.Image = append(anim.Image, frame)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestAnimatedGIFs(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	red, blue := color.RGBA{255, 0, 0, 255}, color.RGBA{0, 0, 255, 255}
+	fixtures := map[string][]byte{
+		"looping-id": encodeAnimatedGIF(t, 0, []int{10, 20, 30}, []color.Color{red, blue, red}),
+		"once-id":    encodeAnimatedGIF(t, -1, []int{5, 5}, []color.Color{blue, red}),
+		"png-id":     encodePNG(t, testImage(20, 10, func(x, y float64) float64 { return x })),
+		"text-id":    []byte("test cat pic data"),
+	}
+	for id, data := range fixtures {
+		if err := insertCatPic(db, id, data); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/poster", PosterCatPic(db)).Methods("GET")
+
+	t.Run("Metadata", func(t *testing.T) {
+		req, _ := http.NewRequest("GET", "/catpics", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+
+		var pics []CatPicResponse
+		if err := json.NewDecoder(rr.Body).Decode(&pics); err != nil {
+			t.Fatal(err)
+		}
+		want := map[string]*Animation{
+			"looping-id": {Frames: 3, DurationMs: 600, LoopCount: 0},
+			"once-id":    {Frames: 2, DurationMs: 100, LoopCount: -1},
+			"png-id":     nil,
+			"text-id":    nil,
+		}
+		for _, pic := range pics {
+			got, want := pic.Animation, want[pic.ID]
+			if (got == nil) != (want == nil) || (got != nil && *got != *want) {
+				t.Errorf("%s: got animation %+v, want %+v", pic.ID, got, want)
+			}
+		}
+	})
+
+	tt := []struct {
+		name       string
+		id         string
+		wantStatus int
+		wantColor  color.Color
+	}{
+		{name: "First Frame", id: "looping-id", wantStatus: http.StatusOK, wantColor: red},
+		{name: "Other Animation", id: "once-id", wantStatus: http.StatusOK, wantColor: blue},
+		{name: "Still Picture", id: "png-id", wantStatus: http.StatusOK},
+		{name: "Undecodable Picture", id: "text-id", wantStatus: http.StatusUnprocessableEntity},
+		{name: "Non-Existing CatPic", id: "non-existing-id", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest("GET", "/catpics/"+tc.id+"/poster", nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			if got := rr.Header().Get("Content-Type"); got != "image/png" {
+				t.Errorf("got Content-Type %q, want image/png", got)
+			}
+			img, format, err := decodeImage(rr.Body.Bytes())
+			if err != nil || format != "png" {
+				t.Fatalf("poster is not a PNG: %v", err)
+			}
+			if size := img.Bounds().Size(); size != image.Pt(20, 10) {
+				t.Errorf("got size %v, want 20x10", size)
+			}
+			if tc.wantColor != nil {
+				got := color.RGBAModel.Convert(img.At(15, 5))
+				if got != color.RGBAModel.Convert(tc.wantColor) {
+					t.Errorf("got colour %v, want %v", got, tc.wantColor)
+				}
+			}
+		})
+	}
+}
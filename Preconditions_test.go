@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestIfMatch(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := insertCatPic(db, "test-id", []byte("version one")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+
+	update := func(data, ifMatch string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.txt")
+		part.Write([]byte(data))
+		writer.Close()
+		req, _ := http.NewRequest("PUT", "/catpics/test-id", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	remove := func(ifMatch string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("DELETE", "/catpics/test-id", nil)
+		if ifMatch != "" {
+			req.Header.Set("If-Match", ifMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	req, _ := http.NewRequest("GET", "/catpics/test-id", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	etag := rr.Header().Get("ETag")
+	if etag != `"1"` {
+		t.Fatalf(`got ETag %q, want "1"`, etag)
+	}
+
+	rr = update("version two", etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+	}
+	if got := rr.Header().Get("ETag"); got != `"2"` {
+		t.Errorf(`got ETag %q after update, want "2"`, got)
+	}
+
+	// A second editor still holding the first ETag loses.
+	if rr := update("version three", etag); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale update: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	if data, _ := loadCatPicData(db, "test-id"); string(data) != "version two" {
+		t.Errorf("stale update overwrote the picture with %q", data)
+	}
+	if rr := update("version three", `W/"2"`); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("weak ETag: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+	}
+	if rr := update("version three", "2"); rr.Code != http.StatusOK {
+		t.Errorf("bare version number: got status %v want %v", rr.Code, http.StatusOK)
+	}
+
+	t.Run("Required", func(t *testing.T) {
+		defer func(require bool) { config.RequireIfMatch = require }(config.RequireIfMatch)
+		config.RequireIfMatch = true
+
+		if rr := update("version four", ""); rr.Code != http.StatusPreconditionRequired {
+			t.Errorf("update: got status %v want %v", rr.Code, http.StatusPreconditionRequired)
+		}
+		if rr := remove(""); rr.Code != http.StatusPreconditionRequired {
+			t.Errorf("delete: got status %v want %v", rr.Code, http.StatusPreconditionRequired)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if rr := remove(`"2"`); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("stale delete: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if rr := remove(`"1", "3"`); rr.Code != http.StatusNoContent {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := remove("*"); rr.Code != http.StatusNotFound {
+			t.Errorf("deleting again: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestProblemResponses(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.NotFoundHandler = http.HandlerFunc(routeNotFound)
+	r.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}", GetCatPicVersion(db)).Methods("GET")
+	r.HandleFunc("/problems/{code}", GetProblemType).Methods("GET")
+
+	tests := []struct {
+		method, path string
+		want         Problem
+	}{
+		{"GET", "/catpics/missing", Problem{
+			Type: "/problems/catpic-not-found", Title: "Cat picture not found", Status: http.StatusNotFound,
+			Detail: "Cat picture not found", Instance: "/catpics/missing", Code: "catpic-not-found",
+		}},
+		{"GET", "/catpics/missing?format=bmp", Problem{
+			Type: "/problems/invalid-parameter", Title: "Invalid parameter", Status: http.StatusBadRequest,
+			Detail: "format must be png, jpeg or gif", Instance: "/catpics/missing", Code: "invalid-parameter",
+		}},
+		{"GET", "/catpics/missing/versions/zero", Problem{
+			Type: "/problems/invalid-parameter", Title: "Invalid parameter", Status: http.StatusBadRequest,
+			Detail: "Invalid version", Instance: "/catpics/missing/versions/zero", Code: "invalid-parameter",
+		}},
+		{"GET", "/nowhere", Problem{
+			Type: "/problems/route-not-found", Title: "No such resource", Status: http.StatusNotFound,
+			Detail: "No resource at /nowhere", Instance: "/nowhere", Code: "route-not-found",
+		}},
+		{"POST", "/catpics/missing", Problem{
+			Type: "/problems/method-not-allowed", Title: "Method not allowed", Status: http.StatusMethodNotAllowed,
+			Detail: "POST is not supported on /catpics/missing", Instance: "/catpics/missing", Code: "method-not-allowed",
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			req, _ := http.NewRequest(tt.method, tt.path, nil)
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tt.want.Status {
+				t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, tt.want.Status)
+			}
+			if ct := rr.Header().Get("Content-Type"); ct != problemContentType {
+				t.Errorf("got Content-Type %q, want %q", ct, problemContentType)
+			}
+			var got Problem
+			if err := json.Unmarshal(rr.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+
+	req, _ := http.NewRequest("GET", "/problems/catpic-trashed", nil)
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	var described Problem
+	json.Unmarshal(rr.Body.Bytes(), &described)
+	if rr.Code != http.StatusOK || described.Status != http.StatusConflict || described.Type != "/problems/catpic-trashed" {
+		t.Errorf("got %v %+v, want a description of catpic-trashed", rr.Code, described)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSearchCatPics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/search", SearchCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", PatchCatPic(db)).Methods("PATCH")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+
+	do := func(method, url, body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewBufferString(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	search := func(q string) []SearchResult {
+		rr := do("GET", "/catpics/search?q="+q, "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("search returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var results []SearchResult
+		if err := json.NewDecoder(rr.Body).Decode(&results); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		return results
+	}
+
+	if !fts5Enabled {
+		// Everything but search works without FTS5; run with -tags sqlite_fts5
+		// to test search itself.
+		if err := insertCatPic(db, "no-fts5", []byte("data")); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+		if rr := do("PATCH", "/catpics/no-fts5", `{"title": "Sleeping kitten"}`); rr.Code != http.StatusOK {
+			t.Errorf("patch returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := do("GET", "/catpics/search?q=kitten", ""); rr.Code != http.StatusNotImplemented {
+			t.Errorf("search returned wrong status code: got %v want %v", rr.Code, http.StatusNotImplemented)
+		}
+		return
+	}
+
+	for id, patch := range map[string]string{
+		"title-match":   `{"title": "Sleeping kitten"}`,
+		"caption-match": `{"caption": "The kitten next door, sleeping in the sun"}`,
+		"no-match":      `{"title": "Grumpy cat"}`,
+	} {
+		if err := insertCatPic(db, id, []byte("data for "+id)); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+		do("PATCH", "/catpics/"+id, patch)
+	}
+
+	t.Run("Ranks Title Above Caption", func(t *testing.T) {
+		results := search("sleeping+kitt")
+		if len(results) != 2 || results[0].ID != "title-match" || results[1].ID != "caption-match" {
+			t.Fatalf("unexpected results: %+v", results)
+		}
+		if !strings.Contains(results[0].Snippet, "<mark>Sleeping</mark>") {
+			t.Errorf("snippet is not highlighted: %q", results[0].Snippet)
+		}
+	})
+
+	t.Run("Syntax Is Escaped", func(t *testing.T) {
+		if results := search(`"grumpy+OR`); len(results) != 0 {
+			t.Errorf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("Deleted Pictures Disappear", func(t *testing.T) {
+		do("DELETE", "/catpics/title-match", "")
+		if results := search("sleeping"); len(results) != 1 {
+			t.Errorf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("Purged Pictures Leave The Index", func(t *testing.T) {
+		if err := withTx(context.Background(), db, func(tx *sql.Tx) error {
+			_, err := deleteCatPicByID(tx, "caption-match")
+			return err
+		}); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM cat_pics_fts WHERE id = 'caption-match'").Scan(&n)
+		if n != 0 {
+			t.Errorf("purged picture still has %d index entries", n)
+		}
+	})
+
+	t.Run("Index Is Kept On Open", func(t *testing.T) {
+		if _, err := db.Exec("INSERT INTO cat_pics_fts (id, title) VALUES ('marker', 'kept')"); err != nil {
+			t.Fatal(err)
+		}
+		defer db.Exec("DELETE FROM cat_pics_fts WHERE id = 'marker'")
+		if err := ensureSearchIndex(db); err != nil {
+			t.Fatal(err)
+		}
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM cat_pics_fts WHERE id = 'marker'").Scan(&n)
+		if n != 1 {
+			t.Error("the index was rebuilt although its definition has not changed")
+		}
+	})
+
+	t.Run("Index Is Rebuilt On Open", func(t *testing.T) {
+		// As left by a build without FTS5, which cannot update the index and
+		// clears its definition when it opens the database.
+		if _, err := db.Exec("UPDATE cat_pics SET title = 'Sleeping tabby' WHERE id = 'no-match'"); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.Exec("DELETE FROM search_index_state"); err != nil {
+			t.Fatal(err)
+		}
+		if err := ensureSearchIndex(db); err != nil {
+			t.Fatal(err)
+		}
+		if results := search("tabby"); len(results) != 1 || results[0].ID != "no-match" {
+			t.Errorf("unexpected results: %+v", results)
+		}
+	})
+
+	t.Run("Missing Query", func(t *testing.T) {
+		if rr := do("GET", "/catpics/search", ""); rr.Code != http.StatusBadRequest {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+}
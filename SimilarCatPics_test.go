@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// testImage draws a w x h picture whose brightness follows shade(x, y) in [0, 1].
+func testImage(w, h int, shade func(x, y float64) float64) image.Image {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			v := uint8(255 * shade(float64(x)/float64(w), float64(y)/float64(h)))
+			img.Set(x, y, color.RGBA{v, v / 2, 255 - v, 255})
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 60}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestSimilarCatPics(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	wave := func(x, y float64) float64 { return (x*x + y) / 2 }
+	fixtures := map[string][]byte{
+		"original":  encodePNG(t, testImage(180, 120, wave)),
+		"resized":   encodeJPEG(t, testImage(90, 60, wave)),
+		"different": encodePNG(t, testImage(180, 120, func(x, y float64) float64 { return 1 - wave(x, y) })),
+		"not-image": []byte("fake cat pic content"),
+	}
+	for id, data := range fixtures {
+		if err := insertCatPic(db, id, data); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}/similar", SimilarCatPics(db)).Methods("GET")
+
+	tt := []struct {
+		name       string
+		url        string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{name: "Resized Copy Matches", url: "/catpics/original/similar", wantStatus: http.StatusOK, wantIDs: []string{"resized"}},
+		{name: "Unrelated Picture", url: "/catpics/different/similar", wantStatus: http.StatusOK, wantIDs: []string{}},
+		{name: "Invalid Distance", url: "/catpics/original/similar?maxDistance=99", wantStatus: http.StatusBadRequest},
+		{name: "Not An Image", url: "/catpics/not-image/similar", wantStatus: http.StatusUnprocessableEntity},
+		{name: "Missing Picture", url: "/catpics/missing/similar", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			req, err := http.NewRequest("GET", tc.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			r.ServeHTTP(rr, req)
+
+			if rr.Code != tc.wantStatus {
+				t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, tc.wantStatus)
+			}
+			if tc.wantIDs == nil {
+				return
+			}
+
+			var matches []SimilarCatPic
+			if err := json.NewDecoder(rr.Body).Decode(&matches); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			ids := []string{}
+			for _, m := range matches {
+				ids = append(ids, m.ID)
+			}
+			if !reflect.DeepEqual(ids, tc.wantIDs) {
+				t.Errorf("got similar pictures %v, want %v", ids, tc.wantIDs)
+			}
+		})
+	}
+}
+
+func TestSimilarityIndexMatchesLinearScan(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	idx := &similarityIndex{byID: map[string]uint64{}}
+	hashes := map[string]uint64{}
+
+	base := rng.Uint64()
+	for i := 0; i < 2000; i++ {
+		// Flip a handful of bits so there are plenty of near neighbours.
+		h := base
+		for j := rng.Intn(20); j > 0; j-- {
+			h ^= 1 << uint(rng.Intn(64))
+		}
+		id := fmt.Sprintf("pic-%d", i)
+		hashes[id] = h
+		idx.add(id, h)
+	}
+	for id := range hashes {
+		if rng.Intn(10) == 0 {
+			idx.remove(id)
+			delete(hashes, id)
+		}
+	}
+
+	for _, maxDistance := range []int{0, 3, 8, 16} {
+		got := idx.search(base, maxDistance)
+
+		want := 0
+		for _, h := range hashes {
+			if hammingDistance(base, h) <= maxDistance {
+				want++
+			}
+		}
+		if len(got) != want {
+			t.Errorf("maxDistance %d: index found %d pictures, linear scan %d", maxDistance, len(got), want)
+		}
+	}
+}
+
+func TestSimilarityIndexSeesOtherWriters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "catpics.sqlite3")
+	db, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	// A second handle stands in for another process, such as the import
+	// command, writing to the same database.
+	other, err := openDB(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+
+	wave := func(x, y float64) float64 { return (x*x + y) / 2 }
+	if err := insertCatPic(db, "original", encodePNG(t, testImage(180, 120, wave))); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}/similar", SimilarCatPics(db)).Methods("GET")
+	similar := func() []SimilarCatPic {
+		req, _ := http.NewRequest("GET", "/catpics/original/similar", nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var matches []SimilarCatPic
+		json.NewDecoder(rr.Body).Decode(&matches)
+		return matches
+	}
+
+	if got := similar(); len(got) != 0 {
+		t.Fatalf("got %v before the copy was added, want none", got)
+	}
+	if err := insertCatPic(other, "resized", encodeJPEG(t, testImage(90, 60, wave))); err != nil {
+		t.Fatal(err)
+	}
+	idx := similarityIndexFor(db)
+	root := idx.root
+	if got := similar(); len(got) != 1 || got[0].ID != "resized" {
+		t.Errorf("got %v after another writer added a copy, want resized", got)
+	}
+	if idx.root != root {
+		t.Error("the index was rebuilt rather than updated with the change")
+	}
+	if _, err := trashCatPic(other, "resized", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if got := similar(); len(got) != 0 {
+		t.Errorf("got %v after another writer trashed the copy, want none", got)
+	}
+
+	// Once the entries the index has not seen are pruned, it is rebuilt.
+	if _, err := restoreCatPic(other, "resized"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Exec("DELETE FROM similarity_changes"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := trashCatPic(other, "original", time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := restoreCatPic(other, "original"); err != nil {
+		t.Fatal(err)
+	}
+	if got := similar(); len(got) != 1 || got[0].ID != "resized" {
+		t.Errorf("got %v after the log was pruned, want resized", got)
+	}
+}
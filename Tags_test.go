@@ -0,0 +1,145 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTags(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/tags", ListCatPicTags(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/tags/{tag}", AddCatPicTag(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}/tags/{tag}", RemoveCatPicTag(db)).Methods("DELETE")
+	r.HandleFunc("/tags", ListTags(db)).Methods("GET")
+	r.HandleFunc("/tags", CreateTag(db)).Methods("POST")
+	r.HandleFunc("/tags/{name}", RenameTag(db)).Methods("PUT")
+	r.HandleFunc("/tags/{name}", DeleteTag(db)).Methods("DELETE")
+
+	do := func(method, url string, body []byte) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	upload := func(content string, tags ...string) string {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.jpg")
+		part.Write([]byte(content))
+		for _, tag := range tags {
+			writer.WriteField("tags", tag)
+		}
+		writer.Close()
+
+		req, _ := http.NewRequest("POST", "/catpics", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusCreated {
+			t.Fatalf("upload returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+
+		var resp CatPicResponse
+		json.NewDecoder(rr.Body).Decode(&resp)
+		return resp.ID
+	}
+
+	listIDs := func(url string) []string {
+		rr := do("GET", url, nil)
+		var pics []CatPicResponse
+		json.NewDecoder(rr.Body).Decode(&pics)
+		ids := []string{}
+		for _, p := range pics {
+			ids = append(ids, p.ID)
+		}
+		return ids
+	}
+
+	sleepy := upload("sleepy kitten", "Kittens, sleeping")
+	playful := upload("playful kitten", "kittens")
+	upload("grumpy cat")
+
+	t.Run("Tags Assigned On Upload", func(t *testing.T) {
+		rr := do("GET", "/catpics/"+sleepy+"/tags", nil)
+		var tags []string
+		json.NewDecoder(rr.Body).Decode(&tags)
+		if !reflect.DeepEqual(tags, []string{"kittens", "sleeping"}) {
+			t.Errorf("got tags %v, want [kittens sleeping]", tags)
+		}
+	})
+
+	t.Run("List Filtered By Tag", func(t *testing.T) {
+		if ids := listIDs("/catpics?tag=kittens"); len(ids) != 2 {
+			t.Errorf("got %d kittens, want 2", len(ids))
+		}
+		if ids := listIDs("/catpics?tag=kittens&tag=sleeping"); !reflect.DeepEqual(ids, []string{sleepy}) {
+			t.Errorf("got %v for sleeping kittens, want [%s]", ids, sleepy)
+		}
+	})
+
+	t.Run("Tag And Untag Picture", func(t *testing.T) {
+		if rr := do("PUT", "/catpics/"+playful+"/tags/sleeping", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("tagging returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if ids := listIDs("/catpics?tag=sleeping"); len(ids) != 2 {
+			t.Errorf("got %d sleeping pictures, want 2", len(ids))
+		}
+		if rr := do("DELETE", "/catpics/"+playful+"/tags/%20Sleeping%20", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("untagging returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+		if rr := do("DELETE", "/catpics/"+playful+"/tags/a,b", nil); rr.Code != http.StatusBadRequest {
+			t.Errorf("untagging with an invalid name returned wrong status code: got %v want %v", rr.Code, http.StatusBadRequest)
+		}
+		if rr := do("PUT", "/catpics/missing/tags/sleeping", nil); rr.Code != http.StatusNotFound {
+			t.Errorf("tagging missing picture returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("Tag CRUD", func(t *testing.T) {
+		if rr := do("POST", "/tags", []byte(`{"name": "Orange"}`)); rr.Code != http.StatusCreated {
+			t.Fatalf("create returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+		}
+		if rr := do("POST", "/tags", []byte(`{"name": "orange"}`)); rr.Code != http.StatusConflict {
+			t.Errorf("duplicate create returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+		}
+		if rr := do("PUT", "/tags/Kittens", []byte(`{"name": "kitties"}`)); rr.Code != http.StatusOK {
+			t.Fatalf("rename returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := do("PUT", "/tags/kitties", []byte(`{"name": "Kitties"}`)); rr.Code != http.StatusOK {
+			t.Errorf("rename to the same name returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := do("PUT", "/tags/kitties", []byte(`{"name": "Orange"}`)); rr.Code != http.StatusConflict {
+			t.Errorf("rename to a taken name returned wrong status code: got %v want %v", rr.Code, http.StatusConflict)
+		}
+		if rr := do("PUT", "/tags/missing", []byte(`{"name": "missing"}`)); rr.Code != http.StatusNotFound {
+			t.Errorf("renaming a missing tag to itself returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := do("DELETE", "/tags/Sleeping", nil); rr.Code != http.StatusNoContent {
+			t.Fatalf("delete returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+		}
+
+		var tags []Tag
+		json.NewDecoder(do("GET", "/tags", nil).Body).Decode(&tags)
+		want := []Tag{{Name: "kitties", Count: 2}, {Name: "orange", Count: 0}}
+		if !reflect.DeepEqual(tags, want) {
+			t.Errorf("got tags %+v, want %+v", tags, want)
+		}
+	})
+}
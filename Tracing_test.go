@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func TestTracing(t *testing.T) {
+	defer func(tp trace.TracerProvider, p propagation.TextMapPropagator) {
+		otel.SetTracerProvider(tp)
+		otel.SetTextMapPropagator(p)
+	}(otel.GetTracerProvider(), otel.GetTextMapPropagator())
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.Use(recordRoute, traceRoute)
+	r.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
+
+	body := new(bytes.Buffer)
+	writer := multipart.NewWriter(body)
+	part, _ := writer.CreateFormFile("catpic", "cat.png")
+	part.Write(encodePNG(t, testImage(16, 16, func(x, y float64) float64 { return x })))
+	writer.Close()
+	req, _ := http.NewRequest("POST", "/catpics", body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	spans := recorder.Ended()
+	byName := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range spans {
+		if span.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Errorf("span %q is not part of the incoming trace", span.Name())
+		}
+		byName[span.Name()] = span
+	}
+
+	server, ok := byName["POST /catpics"]
+	if !ok {
+		t.Fatalf("no server span among %d spans", len(spans))
+	}
+	if server.Parent().SpanID().String() != "00f067aa0ba902b7" || server.SpanKind() != trace.SpanKindServer {
+		t.Errorf("server span has parent %v and kind %v", server.Parent().SpanID(), server.SpanKind())
+	}
+
+	for name, parent := range map[string]string{
+		"prepareCatPic": "POST /catpics",
+		"INSERT":        "POST /catpics",
+		"analyzeCatPic": "POST /catpics",
+	} {
+		span, ok := byName[name]
+		if !ok {
+			t.Errorf("no %q span", name)
+			continue
+		}
+		if span.Parent().SpanID() != byName[parent].SpanContext().SpanID() {
+			t.Errorf("span %q is not a child of %q", name, parent)
+		}
+	}
+}
+
+func TestTracingInTransactions(t *testing.T) {
+	defer func(tp trace.TracerProvider) { otel.SetTracerProvider(tp) }(otel.GetTracerProvider())
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.Use(recordRoute, traceRoute)
+	r.HandleFunc("/albums", CreateAlbum(db)).Methods("POST")
+
+	req, _ := http.NewRequest("POST", "/albums", strings.NewReader(`{"name": "Naps"}`))
+	rr := httptest.NewRecorder()
+	r.ServeHTTP(rr, req)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+
+	var server sdktrace.ReadOnlySpan
+	children := map[string]bool{}
+	for _, span := range recorder.Ended() {
+		if span.Name() == "POST /albums" {
+			server = span
+		}
+	}
+	if server == nil {
+		t.Fatal("no server span")
+	}
+	for _, span := range recorder.Ended() {
+		if span.Parent().SpanID() == server.SpanContext().SpanID() {
+			children[span.Name()] = true
+		}
+	}
+	for _, name := range []string{"INSERT", "DELETE", "SELECT"} {
+		if !children[name] {
+			t.Errorf("no %q span under the request, got %v", name, children)
+		}
+	}
+}
+
+func TestStartSpanOutsideTrace(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	defer func(tp trace.TracerProvider) { otel.SetTracerProvider(tp) }(otel.GetTracerProvider())
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+
+	db := setupTestDB(t)
+	defer db.Close()
+	analyzeCatPicTraced(db, encodePNG(t, image.NewGray(image.Rect(0, 0, 4, 4))))
+	if spans := recorder.Ended(); len(spans) != 0 {
+		t.Errorf("got %d spans outside a trace, want none", len(spans))
+	}
+}
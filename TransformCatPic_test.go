@@ -0,0 +1,132 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTransformCatPic(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	fixtures := map[string][]byte{
+		"png-id":  encodePNG(t, testImage(40, 30, func(x, y float64) float64 { return x })),
+		"jpeg-id": encodeJPEG(t, testImage(40, 30, func(x, y float64) float64 { return y })),
+		"big-id":  encodePNG(t, image.NewGray(image.Rect(0, 0, 1300, 1300))),
+		"text-id": []byte("test cat pic data"),
+	}
+	for id, data := range fixtures {
+		if err := insertCatPic(db, id, data); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}/transform", TransformCatPic(db)).Methods("GET")
+
+	do := func(id, ops, ifNoneMatch string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("GET", "/catpics/"+id+"/transform?ops="+url.QueryEscape(ops), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if ifNoneMatch != "" {
+			req.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	t.Run("Pipeline", func(t *testing.T) {
+		rr := do("png-id", "crop:10,5,20,10|rotate:90|grayscale|blur:2|flip:h", "")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v: %s", rr.Code, http.StatusOK, rr.Body)
+		}
+		if got := rr.Header().Get("Content-Type"); got != "image/png" {
+			t.Errorf("got Content-Type %q, want image/png", got)
+		}
+		img, _, err := decodeImage(rr.Body.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if size := img.Bounds().Size(); size != image.Pt(10, 20) {
+			t.Errorf("got size %v, want 10x20", size)
+		}
+		c := color.NRGBAModel.Convert(img.At(5, 5)).(color.NRGBA)
+		if c.R != c.G || c.G != c.B {
+			t.Errorf("got colour %v, want grey", c)
+		}
+	})
+
+	t.Run("Keeps JPEG", func(t *testing.T) {
+		rr := do("jpeg-id", "rotate:180", "")
+		if got := rr.Header().Get("Content-Type"); rr.Code != http.StatusOK || got != "image/jpeg" {
+			t.Errorf("got %v with Content-Type %q, want 200 image/jpeg", rr.Code, got)
+		}
+	})
+
+	t.Run("ETag", func(t *testing.T) {
+		first := do("png-id", "crop:0,0,10,10|grayscale", "")
+		etag := first.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("missing ETag")
+		}
+
+		again := do("png-id", " crop:0, 0, 10, 10 | grayscale", "")
+		if again.Header().Get("ETag") != etag || again.Body.String() != first.Body.String() {
+			t.Errorf("equivalent ops gave a different result")
+		}
+		if other := do("png-id", "grayscale", ""); other.Header().Get("ETag") == etag {
+			t.Errorf("different ops gave the same ETag")
+		}
+		if other := do("jpeg-id", "crop:0,0,10,10|grayscale", ""); other.Header().Get("ETag") == etag {
+			t.Errorf("different pictures gave the same ETag")
+		}
+
+		if rr := do("png-id", "crop:0,0,10,10|grayscale", etag); rr.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match returned wrong status code: got %v want %v", rr.Code, http.StatusNotModified)
+		}
+	})
+
+	tt := []struct {
+		name       string
+		id         string
+		ops        string
+		wantStatus int
+	}{
+		{name: "Missing Ops", id: "png-id", ops: "", wantStatus: http.StatusBadRequest},
+		{name: "Unknown Op", id: "png-id", ops: "sharpen", wantStatus: http.StatusBadRequest},
+		{name: "Bad Rotation", id: "png-id", ops: "rotate:45", wantStatus: http.StatusBadRequest},
+		{name: "Bad Flip", id: "png-id", ops: "flip:x", wantStatus: http.StatusBadRequest},
+		{name: "Wrong Argument Count", id: "png-id", ops: "crop:1,2,3", wantStatus: http.StatusBadRequest},
+		{name: "Blur Radius Too Large", id: "png-id", ops: "blur:21", wantStatus: http.StatusBadRequest},
+		{name: "Too Many Ops", id: "png-id", ops: strings.Repeat("grayscale|", 10) + "grayscale", wantStatus: http.StatusBadRequest},
+		{name: "Crop Outside Picture", id: "png-id", ops: "rotate:90|crop:0,0,40,30", wantStatus: http.StatusBadRequest},
+		{name: "Too Expensive", id: "big-id", ops: strings.Repeat("blur:20|", 9) + "blur:20", wantStatus: http.StatusUnprocessableEntity},
+		{name: "Undecodable Picture", id: "text-id", ops: "grayscale", wantStatus: http.StatusUnprocessableEntity},
+		{name: "Non-Existing CatPic", id: "non-existing-id", ops: "grayscale", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tc := range tt {
+		t.Run(tc.name, func(t *testing.T) {
+			// If-None-Match: * must not turn a failure into 304 Not Modified.
+			for _, ifNoneMatch := range []string{"", "*"} {
+				rr := do(tc.id, tc.ops, ifNoneMatch)
+				if rr.Code != tc.wantStatus {
+					t.Errorf("If-None-Match %q: handler returned wrong status code: got %v want %v", ifNoneMatch, rr.Code, tc.wantStatus)
+				}
+				if etag := rr.Header().Get("ETag"); etag != "" {
+					t.Errorf("If-None-Match %q: error response carries ETag %s", ifNoneMatch, etag)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestTrash(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	for _, id := range []string{"id1", "id2"} {
+		if err := insertCatPic(db, id, []byte("test data "+id)); err != nil {
+			t.Fatalf("Failed to insert test record: %v", err)
+		}
+	}
+	if err := tagCatPic(db, "id1", []string{"tabby"}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+	r.HandleFunc("/catpics/{id}/restore", RestoreCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics/{id}/tags", ListCatPicTags(db)).Methods("GET")
+	r.HandleFunc("/trash", ListTrash(db)).Methods("GET")
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest(method, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	listIDs := func() []string {
+		var pics []CatPicResponse
+		json.NewDecoder(do("GET", "/catpics").Body).Decode(&pics)
+		ids := []string{}
+		for _, pic := range pics {
+			ids = append(ids, pic.ID)
+		}
+		return ids
+	}
+
+	if rr := do("DELETE", "/catpics/id1"); rr.Code != http.StatusNoContent {
+		t.Fatalf("delete returned wrong status code: got %v want %v", rr.Code, http.StatusNoContent)
+	}
+
+	t.Run("Hidden", func(t *testing.T) {
+		if ids := listIDs(); len(ids) != 1 || ids[0] != "id2" {
+			t.Errorf("got pictures %v, want only id2", ids)
+		}
+		if rr := do("GET", "/catpics/id1"); rr.Code != http.StatusNotFound {
+			t.Errorf("get returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := do("GET", "/catpics/id1/tags"); rr.Code != http.StatusNotFound {
+			t.Errorf("tags returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+
+	t.Run("List Trash", func(t *testing.T) {
+		var trash []TrashedCatPic
+		json.NewDecoder(do("GET", "/trash").Body).Decode(&trash)
+		if len(trash) != 1 || trash[0].ID != "id1" {
+			t.Fatalf("got trash %+v, want only id1", trash)
+		}
+		deletedAt, err := time.Parse(time.RFC3339, trash[0].DeletedAt)
+		if err != nil || time.Since(deletedAt) > time.Minute {
+			t.Errorf("got deletedAt %q", trash[0].DeletedAt)
+		}
+		if want := deletedAt.Add(config.TrashRetention).Format(time.RFC3339); trash[0].PurgeAt != want {
+			t.Errorf("got purgeAt %q, want %q", trash[0].PurgeAt, want)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		if rr := do("POST", "/catpics/id2/restore"); rr.Code != http.StatusNotFound {
+			t.Errorf("restoring a live picture returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := do("POST", "/catpics/id1/restore"); rr.Code != http.StatusOK {
+			t.Fatalf("restore returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		if rr := do("GET", "/catpics/id1"); rr.Code != http.StatusOK {
+			t.Errorf("get after restore returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+
+		var tags []string
+		json.NewDecoder(do("GET", "/catpics/id1/tags").Body).Decode(&tags)
+		if len(tags) != 1 || tags[0] != "tabby" {
+			t.Errorf("got tags %v after restore, want [tabby]", tags)
+		}
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		do("DELETE", "/catpics/id2")
+
+		if ids, err := purgeTrash(db, time.Now().Add(-time.Hour)); err != nil || len(ids) != 0 {
+			t.Fatalf("purged %v (%v) before the retention period ended", ids, err)
+		}
+		ids, err := purgeTrash(db, time.Now())
+		if err != nil || len(ids) != 1 || ids[0] != "id2" {
+			t.Fatalf("purged %v (%v), want [id2]", ids, err)
+		}
+		if rr := do("POST", "/catpics/id2/restore"); rr.Code != http.StatusNotFound {
+			t.Errorf("restoring a purged picture returned wrong status code: got %v want %v", rr.Code, http.StatusNotFound)
+		}
+	})
+}
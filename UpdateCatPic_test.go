@@ -18,7 +18,7 @@ func TestUpdateCatPic(t *testing.T) {
 
     // Insert a test record to update
     testID := uuid.NewString()
-    _, err := db.Exec("INSERT INTO cat_pics (id, data) VALUES (?, ?)", testID, []byte("original data"))
+    err := insertCatPic(db, testID, []byte("original data"))
     if err != nil {
         t.Fatalf("Failed to insert test record: %v", err)
     }
@@ -56,8 +56,7 @@ func TestUpdateCatPic(t *testing.T) {
     }
 
     // Verify the record was updated in the database
-    var newData []byte
-    err = db.QueryRow("SELECT data FROM cat_pics WHERE id = ?", testID).Scan(&newData)
+    newData, err := loadCatPicData(db, testID)
     if err != nil {
         t.Fatalf("Failed to fetch updated record: %v", err)
     }
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestUpdateCatPicCreates(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+
+	put := func(id, data string, header ...string) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.txt")
+		part.Write([]byte(data))
+		writer.Close()
+		req, _ := http.NewRequest("PUT", "/catpics/"+id, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+
+	rr := put("mirrored-cat_1", "first")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	if loc, etag := rr.Header().Get("Location"), rr.Header().Get("ETag"); loc != "/catpics/mirrored-cat_1" || etag != `"1"` {
+		t.Errorf("got Location %q and ETag %q", loc, etag)
+	}
+	if data, err := loadCatPicData(db, "mirrored-cat_1"); err != nil || string(data) != "first" {
+		t.Errorf("got data %q, %v, want %q", data, err, "first")
+	}
+
+	if rr := put("mirrored-cat_1", "second"); rr.Code != http.StatusOK || rr.Header().Get("ETag") != `"2"` {
+		t.Errorf("update: got status %v and ETag %q, want 200 and \"2\"", rr.Code, rr.Header().Get("ETag"))
+	}
+
+	t.Run("Preconditions", func(t *testing.T) {
+		if rr := put("mirrored-cat_1", "third", "If-None-Match", "*"); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("If-None-Match on an existing picture: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if rr := put("other-cat", "first", "If-Match", `"1"`); rr.Code != http.StatusPreconditionFailed {
+			t.Errorf("If-Match on a missing picture: got status %v want %v", rr.Code, http.StatusPreconditionFailed)
+		}
+		if rr := put("other-cat", "first", "If-None-Match", "*"); rr.Code != http.StatusCreated {
+			t.Errorf("If-None-Match on a missing picture: got status %v want %v", rr.Code, http.StatusCreated)
+		}
+	})
+
+	t.Run("Invalid IDs", func(t *testing.T) {
+		for _, id := range []string{"cat.jpg", "search", "-cat", "cat%20pic", string(bytes.Repeat([]byte("a"), 65))} {
+			if rr := put(id, "first"); rr.Code != http.StatusBadRequest {
+				t.Errorf("%q: got status %v want %v", id, rr.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("Trashed", func(t *testing.T) {
+		if _, err := trashCatPic(db, "other-cat", time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		if rr := put("other-cat", "again"); rr.Code != http.StatusConflict {
+			t.Errorf("got status %v want %v", rr.Code, http.StatusConflict)
+		}
+	})
+}
+
+func TestNewID(t *testing.T) {
+	defer func(v7 bool) { config.TimeOrderedIDs = v7 }(config.TimeOrderedIDs)
+
+	for _, tc := range []struct {
+		timeOrdered bool
+		version     uuid.Version
+	}{
+		{false, 4},
+		{true, 7},
+	} {
+		config.TimeOrderedIDs = tc.timeOrdered
+		id, err := uuid.Parse(newID())
+		if err != nil || id.Version() != tc.version {
+			t.Errorf("TimeOrderedIDs=%v: got %v (%v), want a version %d UUID", tc.timeOrdered, id, err, tc.version)
+		}
+		if !validCatPicID(id.String()) {
+			t.Errorf("generated ID %v is not a valid picture ID", id)
+		}
+	}
+}
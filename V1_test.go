@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestVersionedAPI(t *testing.T) {
+	db := setupTestDB(t)
+	defer db.Close()
+
+	r := mux.NewRouter()
+	v1 := r.PathPrefix(apiPrefix).Subrouter()
+	v1.Use(versionedAPI)
+	v1.HandleFunc("/catpics/{id}/data", GetCatPicByID(db)).Methods("GET")
+	apiRoutes(v1, db, GetCatPic(db))
+	legacy := r.NewRoute().Subrouter()
+	legacy.Use(deprecatedAPI)
+	apiRoutes(legacy, db, GetCatPicByID(db))
+
+	upload := func(method, path string, data []byte) *httptest.ResponseRecorder {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.png")
+		part.Write(data)
+		writer.Close()
+		req, _ := http.NewRequest(method, path, body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	get := func(path string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest("GET", path, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	decode := func(rr *httptest.ResponseRecorder, v interface{}) {
+		t.Helper()
+		if err := json.Unmarshal(rr.Body.Bytes(), v); err != nil {
+			t.Fatalf("%v: %s", err, rr.Body.String())
+		}
+	}
+
+	first := encodePNG(t, testImage(16, 16, func(x, y float64) float64 { return x }))
+	rr := upload("POST", "/v1/catpics", first)
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create returned wrong status code: got %v want %v", rr.Code, http.StatusCreated)
+	}
+	var created CatPicEnvelope
+	decode(rr, &created)
+	id := created.Data.ID
+	self := "/v1/catpics/" + id
+	if rr.Header().Get("Location") != self || created.Data.Links.Self != self || created.Data.Links.Content != self+"/data" || created.Data.Version != 1 {
+		t.Errorf("create returned Location %q and %+v", rr.Header().Get("Location"), created)
+	}
+	if rr.Header().Get("Deprecation") != "" {
+		t.Error("a /v1 response is marked deprecated")
+	}
+
+	rr = get(self)
+	var fetched CatPicEnvelope
+	decode(rr, &fetched)
+	// The version only changes with the image, so it would not tell a
+	// cache about metadata updates.
+	if rr.Code != http.StatusOK || fetched.Data.ID != id || fetched.Data.Version != 1 || rr.Header().Get("ETag") != "" {
+		t.Errorf("get returned %v, ETag %q and %+v", rr.Code, rr.Header().Get("ETag"), fetched)
+	}
+	if rr := get(self + "/data"); rr.Code != http.StatusOK || !bytes.Equal(rr.Body.Bytes(), first) {
+		t.Errorf("content returned %v with %d bytes, want the upload", rr.Code, rr.Body.Len())
+	}
+
+	rr = upload("PUT", self, encodePNG(t, testImage(16, 16, func(x, y float64) float64 { return y })))
+	var updated CatPicEnvelope
+	decode(rr, &updated)
+	if rr.Code != http.StatusOK || updated.Data.ID != id || updated.Data.Version != 2 || updated.Data.Links.Versions != self+"/versions" {
+		t.Errorf("update returned %v and %+v", rr.Code, updated)
+	}
+
+	rr = get("/v1/catpics?ids=" + id)
+	var list CatPicListEnvelope
+	decode(rr, &list)
+	if len(list.Data) != 1 || list.Data[0].Links.Self != self || list.Links.Self != "/v1/catpics?ids="+id {
+		t.Errorf("list returned %+v", list)
+	}
+
+	t.Run("legacy", func(t *testing.T) {
+		rr := upload("POST", "/catpics", first)
+		var pic map[string]interface{}
+		decode(rr, &pic)
+		if rr.Code != http.StatusCreated || len(pic) != 1 || pic["id"] == "" {
+			t.Errorf("create returned %v and %v, want just the ID", rr.Code, pic)
+		}
+
+		rr = get("/catpics/" + id)
+		if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "image/png" {
+			t.Errorf("get returned %v and %q, want the image", rr.Code, rr.Header().Get("Content-Type"))
+		}
+		for header, want := range map[string]string{
+			"Deprecation": "@1792368000",
+			"Sunset":      "Mon, 19 Apr 2027 00:00:00 GMT",
+			"Link":        "<" + self + `/data>; rel="successor-version"`,
+		} {
+			if got := rr.Header().Get(header); got != want {
+				t.Errorf("got %s %q, want %q", header, got, want)
+			}
+		}
+		if rr := get("/catpics/" + id + "/versions"); rr.Header().Get("Link") != "<"+self+`/versions>; rel="successor-version"` {
+			t.Errorf("versions: got Link %q", rr.Header().Get("Link"))
+		}
+	})
+}
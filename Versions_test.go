@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestCatPicVersions(t *testing.T) {
+	defer func(n int) { config.MaxVersions = n }(config.MaxVersions)
+	config.MaxVersions = 2
+
+	db := setupTestDB(t)
+	defer db.Close()
+
+	if err := insertCatPic(db, "test-id", []byte("version one")); err != nil {
+		t.Fatalf("Failed to insert test record: %v", err)
+	}
+
+	r := mux.NewRouter()
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+	r.HandleFunc("/catpics/{id}/versions", ListCatPicVersions(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}", GetCatPicVersion(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}/restore", RestoreCatPicVersion(db)).Methods("POST")
+
+	do := func(method, url string) *httptest.ResponseRecorder {
+		req, _ := http.NewRequest(method, url, nil)
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		return rr
+	}
+	update := func(data string) {
+		body := new(bytes.Buffer)
+		writer := multipart.NewWriter(body)
+		part, _ := writer.CreateFormFile("catpic", "cat.txt")
+		part.Write([]byte(data))
+		writer.Close()
+		req, _ := http.NewRequest("PUT", "/catpics/test-id", body)
+		req.Header.Set("Content-Type", writer.FormDataContentType())
+		rr := httptest.NewRecorder()
+		r.ServeHTTP(rr, req)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("update returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+	}
+	versions := func() []int {
+		rr := do("GET", "/catpics/test-id/versions")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var list []CatPicVersion
+		json.NewDecoder(rr.Body).Decode(&list)
+		var ns []int
+		for i, v := range list {
+			if v.Current != (i == 0) {
+				t.Errorf("version %d has current = %v", v.Version, v.Current)
+			}
+			ns = append(ns, v.Version)
+		}
+		return ns
+	}
+	blobCount := func() int {
+		var n int
+		db.QueryRow("SELECT COUNT(*) FROM blobs").Scan(&n)
+		return n
+	}
+
+	update("version two")
+	update("version two") // unchanged bytes don't make a version
+	update("version three")
+	update("version four")
+
+	if got := versions(); len(got) != 3 || got[0] != 4 || got[1] != 3 || got[2] != 2 {
+		t.Errorf("got versions %v, want [4 3 2]", got)
+	}
+	if n := blobCount(); n != 3 {
+		t.Errorf("got %d blobs, want 3 after pruning the oldest version", n)
+	}
+
+	t.Run("Get", func(t *testing.T) {
+		rr := do("GET", "/catpics/test-id/versions/2")
+		if rr.Code != http.StatusOK || rr.Body.String() != "version two" {
+			t.Errorf("got %v %q, want 200 %q", rr.Code, rr.Body.String(), "version two")
+		}
+		if rr := do("GET", "/catpics/test-id/versions/4"); rr.Body.String() != "version four" {
+			t.Errorf("got current version %q, want %q", rr.Body.String(), "version four")
+		}
+		if rr := do("GET", "/catpics/test-id/versions/1"); rr.Code != http.StatusNotFound {
+			t.Errorf("pruned version: got status %v want %v", rr.Code, http.StatusNotFound)
+		}
+		if rr := do("GET", "/catpics/test-id/versions/x"); rr.Code != http.StatusBadRequest {
+			t.Errorf("invalid version: got status %v want %v", rr.Code, http.StatusBadRequest)
+		}
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		if rr := do("POST", "/catpics/test-id/versions/4/restore"); rr.Code != http.StatusConflict {
+			t.Errorf("restoring the current version: got status %v want %v", rr.Code, http.StatusConflict)
+		}
+
+		rr := do("POST", "/catpics/test-id/versions/2/restore")
+		if rr.Code != http.StatusOK {
+			t.Fatalf("handler returned wrong status code: got %v want %v", rr.Code, http.StatusOK)
+		}
+		var restored CatPicVersion
+		json.NewDecoder(rr.Body).Decode(&restored)
+		if restored.Version != 5 || !restored.Current || restored.Size != len("version two") {
+			t.Errorf("got %+v, want current version 5", restored)
+		}
+
+		if rr.Header().Get("ETag") != pictureETag(5) {
+			t.Errorf("got ETag %q, want %q", rr.Header().Get("ETag"), pictureETag(5))
+		}
+
+		for url, code := range map[string]string{
+			"/catpics/test-id/versions/1/restore": "version-not-found",
+			"/catpics/missing/versions/1/restore": "catpic-not-found",
+		} {
+			rr := do("POST", url)
+			var problem Problem
+			json.NewDecoder(rr.Body).Decode(&problem)
+			if rr.Code != http.StatusNotFound || problem.Code != code {
+				t.Errorf("%s: got status %v and problem %q, want %v and %q", url, rr.Code, problem.Code, http.StatusNotFound, code)
+			}
+		}
+
+		req, _ := http.NewRequest("POST", "/catpics/test-id/versions/3/restore", nil)
+		req.Header.Set("If-Match", pictureETag(4))
+		stale := httptest.NewRecorder()
+		r.ServeHTTP(stale, req)
+		if stale.Code != http.StatusPreconditionFailed {
+			t.Errorf("restoring with a stale If-Match: got status %v want %v", stale.Code, http.StatusPreconditionFailed)
+		}
+
+		data, err := loadCatPicData(db, "test-id")
+		if err != nil || string(data) != "version two" {
+			t.Errorf("got data %q, %v, want %q", data, err, "version two")
+		}
+		if got := versions(); len(got) != 3 || got[0] != 5 || got[1] != 4 || got[2] != 3 {
+			t.Errorf("got versions %v, want [5 4 3]", got)
+		}
+
+		update("version four")
+		if rr := do("POST", "/catpics/test-id/versions/4/restore"); rr.Code != http.StatusConflict {
+			t.Errorf("restoring a version with the current bytes: got status %v want %v", rr.Code, http.StatusConflict)
+		}
+		if got := versions(); len(got) != 3 || got[0] != 6 {
+			t.Errorf("got versions %v, want the current version to stay 6", got)
+		}
+	})
+
+	t.Run("Trashed", func(t *testing.T) {
+		if rr := do("DELETE", "/catpics/test-id"); rr.Code != http.StatusNoContent {
+			t.Fatalf("delete returned wrong status code: got %v", rr.Code)
+		}
+		for _, url := range []string{"/catpics/test-id/versions", "/catpics/test-id/versions/4"} {
+			if rr := do("GET", url); rr.Code != http.StatusNotFound {
+				t.Errorf("%s: got status %v want %v", url, rr.Code, http.StatusNotFound)
+			}
+		}
+
+		if _, err := purgeTrash(db, time.Now().Add(time.Hour)); err != nil {
+			t.Fatal(err)
+		}
+		if n := blobCount(); n != 0 {
+			t.Errorf("got %d blobs after purging, want 0", n)
+		}
+	})
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxAlbumNameLength = 200
+
+type Album struct {
+	ID       string   `json:"id"`
+	Name     string   `json:"name"`
+	Pictures []string `json:"pictures"`
+}
+
+type AlbumRequest struct {
+	Name     string   `json:"name"`
+	Pictures []string `json:"pictures"`
+}
+
+type AlbumPictureRequest struct {
+	ID string `json:"id"`
+}
+
+// albumRequestError is returned when an album request refers to pictures it
+// cannot contain.
+type albumRequestError struct {
+	message string
+}
+
+func (e albumRequestError) Error() string { return e.message }
+
+// validate checks the name and that the picture list has no duplicates.
+func (req *AlbumRequest) validate() error {
+	req.Name = strings.TrimSpace(req.Name)
+	if req.Name == "" || len(req.Name) > maxAlbumNameLength {
+		return albumRequestError{"Album name must be 1-200 characters"}
+	}
+
+	seen := map[string]bool{}
+	for _, id := range req.Pictures {
+		if seen[id] {
+			return albumRequestError{fmt.Sprintf("Cat picture %s is listed twice", id)}
+		}
+		seen[id] = true
+	}
+	return nil
+}
+
+// setAlbumPictures replaces the ordered picture list of an album. Members in
+// the trash are not listed to clients, so they keep their place rather than
+// being dropped, and are back in the album if they are restored.
+func setAlbumPictures(tx dbtx, albumID string, pictures []string) error {
+	if _, err := tx.Exec(`DELETE FROM album_pics WHERE album_id = ?
+		AND cat_pic_id NOT IN (SELECT id FROM cat_pics WHERE deleted_at IS NOT NULL)`, albumID); err != nil {
+		return err
+	}
+
+	for i, id := range pictures {
+		exists, err := catPicExists(tx, id)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return albumRequestError{fmt.Sprintf("Cat picture %s not found", id)}
+		}
+
+		if _, err := tx.Exec("INSERT INTO album_pics (album_id, cat_pic_id, position) VALUES (?, ?, ?)", albumID, id, i); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadAlbum reads an album and its pictures in order.
+func loadAlbum(q dbtx, id string) (Album, error) {
+	album := Album{ID: id, Pictures: []string{}}
+	if err := q.QueryRow("SELECT name FROM albums WHERE id = ?", id).Scan(&album.Name); err != nil {
+		return album, err
+	}
+
+	rows, err := q.Query(`SELECT ap.cat_pic_id FROM album_pics ap
+		JOIN cat_pics p ON p.id = ap.cat_pic_id
+		WHERE ap.album_id = ? AND p.deleted_at IS NULL
+		ORDER BY ap.position`, id)
+	if err != nil {
+		return album, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var picID string
+		if err := rows.Scan(&picID); err != nil {
+			return album, err
+		}
+		album.Pictures = append(album.Pictures, picID)
+	}
+	return album, rows.Err()
+}
+
+// writeAlbumError maps errors from album writes to responses.
+func writeAlbumError(w http.ResponseWriter, r *http.Request, err error) {
+	var reqErr albumRequestError
+	if errors.As(err, &reqErr) {
+		writeProblem(w, r, problemInvalidBody, reqErr.message)
+		return
+	}
+	serverError(w, r, "Server error", err)
+}
+
+// listAlbums godoc
+// @Summary List albums
+// @Description List every album with its pictures in order
+// @Tags albums
+// @Produce  json
+// @Success 200 {array} Album
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums [get]
+func ListAlbums(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := traced(r.Context(), db)
+		rows, err := q.Query("SELECT id FROM albums ORDER BY name, id")
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		var ids []string
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				serverError(w, r, "Server error", err)
+				return
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		albums := []Album{}
+		for _, id := range ids {
+			album, err := loadAlbum(q, id)
+			if err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			albums = append(albums, album)
+		}
+
+		jsonResponse(w, albums, http.StatusOK)
+	}
+}
+
+// createAlbum godoc
+// @Summary Create an album
+// @Description Create an album, optionally with an ordered list of pictures
+// @Tags albums
+// @Accept  json
+// @Produce  json
+// @Param   album  body  AlbumRequest  true  "Album"
+// @Success 201 {object} Album
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums [post]
+func CreateAlbum(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req AlbumRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		if err := req.validate(); err != nil {
+			writeAlbumError(w, r, err)
+			return
+		}
+
+		id := newID()
+
+		var album Album
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			if _, err := q.Exec("INSERT INTO albums (id, name) VALUES (?, ?)", id, req.Name); err != nil {
+				return err
+			}
+			if err := setAlbumPictures(q, id, req.Pictures); err != nil {
+				return err
+			}
+			var err error
+			album, err = loadAlbum(q, id)
+			return err
+		})
+		if err != nil {
+			writeAlbumError(w, r, err)
+			return
+		}
+
+		jsonResponse(w, album, http.StatusCreated)
+	}
+}
+
+// getAlbum godoc
+// @Summary Get an album
+// @Description Get an album with its pictures in order
+// @Tags albums
+// @Produce  json
+// @Param   id  path  string  true  "Album ID"
+// @Success 200 {object} Album
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums/{id} [get]
+func GetAlbum(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		album, err := loadAlbum(traced(r.Context(), db), mux.Vars(r)["id"])
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemAlbumNotFound, "Album not found")
+		case err != nil:
+			serverError(w, r, "Server error", err)
+		default:
+			jsonResponse(w, album, http.StatusOK)
+		}
+	}
+}
+
+// updateAlbum godoc
+// @Summary Update an album
+// @Description Replace an album's name and ordered picture list
+// @Tags albums
+// @Accept  json
+// @Produce  json
+// @Param   id     path  string        true  "Album ID"
+// @Param   album  body  AlbumRequest  true  "Album"
+// @Success 200 {object} Album
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums/{id} [put]
+func UpdateAlbum(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var req AlbumRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		if err := req.validate(); err != nil {
+			writeAlbumError(w, r, err)
+			return
+		}
+
+		var album Album
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			result, err := q.Exec("UPDATE albums SET name = ? WHERE id = ?", req.Name, id)
+			if err != nil {
+				return err
+			}
+			if n, err := result.RowsAffected(); err != nil || n == 0 {
+				return sql.ErrNoRows
+			}
+			if err := setAlbumPictures(q, id, req.Pictures); err != nil {
+				return err
+			}
+			album, err = loadAlbum(q, id)
+			return err
+		})
+		if err == sql.ErrNoRows {
+			writeProblem(w, r, problemAlbumNotFound, "Album not found")
+			return
+		}
+		if err != nil {
+			writeAlbumError(w, r, err)
+			return
+		}
+
+		jsonResponse(w, album, http.StatusOK)
+	}
+}
+
+// deleteAlbum godoc
+// @Summary Delete an album
+// @Description Delete an album. The pictures in it are kept.
+// @Tags albums
+// @Param   id  path  string  true  "Album ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums/{id} [delete]
+func DeleteAlbum(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var found bool
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			if _, err := q.Exec("DELETE FROM album_pics WHERE album_id = ?", id); err != nil {
+				return err
+			}
+			result, err := q.Exec("DELETE FROM albums WHERE id = ?", id)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			found = n > 0
+			return err
+		})
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		if !found {
+			writeProblem(w, r, problemAlbumNotFound, "Album not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// addAlbumPicture godoc
+// @Summary Add a picture to an album
+// @Description Append a cat picture to the end of an album
+// @Tags albums
+// @Accept  json
+// @Produce  json
+// @Param   id       path  string               true  "Album ID"
+// @Param   picture  body  AlbumPictureRequest  true  "Cat Picture"
+// @Success 200 {object} Album
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums/{id}/pictures [post]
+func AddAlbumPicture(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var req AlbumPictureRequest
+		if err := decodeJSONBody(w, r, &req); err != nil || req.ID == "" {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		var album Album
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			var member bool
+			err := q.QueryRow(`SELECT EXISTS (SELECT 1 FROM album_pics WHERE album_id = ? AND cat_pic_id = ?)
+				FROM albums WHERE id = ?`, id, req.ID, id).Scan(&member)
+			if err != nil {
+				return err
+			}
+			if member {
+				return albumRequestError{"Cat picture is already in the album"}
+			}
+			exists, err := catPicExists(q, req.ID)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				return albumRequestError{fmt.Sprintf("Cat picture %s not found", req.ID)}
+			}
+
+			if _, err := q.Exec(`INSERT INTO album_pics (album_id, cat_pic_id, position)
+				SELECT ?, ?, COALESCE(MAX(position), -1) + 1 FROM album_pics WHERE album_id = ?`, id, req.ID, id); err != nil {
+				return err
+			}
+			album, err = loadAlbum(q, id)
+			return err
+		})
+		if err == sql.ErrNoRows {
+			writeProblem(w, r, problemAlbumNotFound, "Album not found")
+			return
+		}
+		if err != nil {
+			writeAlbumError(w, r, err)
+			return
+		}
+
+		jsonResponse(w, album, http.StatusOK)
+	}
+}
+
+// removeAlbumPicture godoc
+// @Summary Remove a picture from an album
+// @Description Remove a cat picture from an album. The picture itself is kept.
+// @Tags albums
+// @Param   id         path  string  true  "Album ID"
+// @Param   pictureId  path  string  true  "Cat Picture ID"
+// @Success 204 "No Content"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/albums/{id}/pictures/{pictureId} [delete]
+func RemoveAlbumPicture(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		result, err := traced(r.Context(), db).Exec("DELETE FROM album_pics WHERE album_id = ? AND cat_pic_id = ?", vars["id"], vars["pictureId"])
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		if n, err := result.RowsAffected(); err != nil || n == 0 {
+			writeProblem(w, r, problemCatPicNotInAlbum, "Cat picture is not in this album")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
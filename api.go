@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// apiPrefix is the path prefix of the current API version.
+const apiPrefix = "/v1"
+
+// legacyDeprecatedAt is when the unversioned routes were deprecated in favour
+// of /v1.
+var legacyDeprecatedAt = time.Date(2026, time.October, 19, 0, 0, 0, 0, time.UTC)
+
+// CatPicLinks point to a picture and the resources that belong to it.
+type CatPicLinks struct {
+	Self string `json:"self" example:"/v1/catpics/c4t"`
+	// Content serves the image itself.
+	Content  string `json:"content" example:"/v1/catpics/c4t/data"`
+	Versions string `json:"versions" example:"/v1/catpics/c4t/versions"`
+	Tags     string `json:"tags" example:"/v1/catpics/c4t/tags"`
+}
+
+// CatPicResource is a picture as the /v1 API represents it.
+type CatPicResource struct {
+	CatPicResponse
+	Links CatPicLinks `json:"links"`
+}
+
+// CatPicEnvelope is the body of /v1 responses that return one picture.
+type CatPicEnvelope struct {
+	Data CatPicResource `json:"data"`
+}
+
+// ListLinks point to a listing.
+type ListLinks struct {
+	Self string `json:"self" example:"/v1/catpics?tag=tabby"`
+}
+
+// CatPicListEnvelope is the body of /v1 responses that return pictures.
+type CatPicListEnvelope struct {
+	Data  []CatPicResource `json:"data"`
+	Links ListLinks        `json:"links"`
+}
+
+type apiVersionKey struct{}
+
+// versionedAPI is middleware for the /v1 routes. Handlers shared with the
+// unversioned routes check isV1 to decide how to shape their responses.
+func versionedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiVersionKey{}, 1)))
+	})
+}
+
+// isV1 reports whether r came in through the /v1 routes.
+func isV1(r *http.Request) bool {
+	return r.Context().Value(apiVersionKey{}) == 1
+}
+
+// deprecatedAPI is middleware for the unversioned routes, which are kept for
+// existing clients. It announces when they were deprecated and when they go
+// away (RFC 9745 and RFC 8594), and links to the /v1 equivalent.
+func deprecatedAPI(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		successor := apiPrefix + r.URL.EscapedPath()
+		// GET /catpics/{id} serves the image, which /v1 serves from /data.
+		if route := mux.CurrentRoute(r); route != nil && r.Method == http.MethodGet {
+			if tpl, _ := route.GetPathTemplate(); tpl == "/catpics/{id}" {
+				successor += "/data"
+			}
+		}
+		w.Header().Set("Deprecation", "@"+strconv.FormatInt(legacyDeprecatedAt.Unix(), 10))
+		w.Header().Set("Sunset", config.LegacySunset.UTC().Format(http.TimeFormat))
+		w.Header().Set("Link", "<"+successor+`>; rel="successor-version"`)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// catPicPath is the path of the picture id in the API version r uses.
+func catPicPath(r *http.Request, id string) string {
+	path := "/catpics/" + url.PathEscape(id)
+	if isV1(r) {
+		return apiPrefix + path
+	}
+	return path
+}
+
+func catPicResource(pic CatPicResponse) CatPicResource {
+	self := apiPrefix + "/catpics/" + url.PathEscape(pic.ID)
+	return CatPicResource{
+		CatPicResponse: pic,
+		Links: CatPicLinks{
+			Self:     self,
+			Content:  self + "/data",
+			Versions: self + "/versions",
+			Tags:     self + "/tags",
+		},
+	}
+}
+
+// catPicBody returns the body of a response about the picture id, such as
+// one just created or updated: the full resource for /v1 requests and just
+// the ID for unversioned ones.
+func catPicBody(q dbtx, r *http.Request, id string) (interface{}, error) {
+	if !isV1(r) {
+		return CatPic{ID: id}, nil
+	}
+	pic, err := scanCatPicResponse(q.QueryRow("SELECT "+catPicResponseColumns+" FROM cat_pics p WHERE p.id = ? AND p.deleted_at IS NULL", id))
+	if err != nil {
+		return nil, err
+	}
+	return CatPicEnvelope{Data: catPicResource(pic)}, nil
+}
+
+// writeCatPic responds with pic, enveloped for /v1 requests.
+func writeCatPic(w http.ResponseWriter, r *http.Request, pic CatPicResponse, status int) {
+	if isV1(r) {
+		jsonResponse(w, CatPicEnvelope{Data: catPicResource(pic)}, status)
+		return
+	}
+	jsonResponse(w, pic, status)
+}
+
+// getCatPic godoc
+// @Summary Get a cat picture's metadata
+// @Description Get the metadata of a cat picture, with links to the image and the picture's versions and tags. The response carries no ETag, as the picture's version only changes with its image; use the version field for If-Match.
+// @Tags catpics
+// @Produce  json
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Success 200 {object} CatPicEnvelope
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id} [get]
+func GetCatPic(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		pic, err := scanCatPicResponse(traced(r.Context(), db).QueryRow("SELECT "+catPicResponseColumns+" FROM cat_pics p WHERE p.id = ? AND p.deleted_at IS NULL", id))
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		case err != nil:
+			serverError(w, r, "Server error", err)
+			return
+		}
+		writeCatPic(w, r, pic, http.StatusOK)
+	}
+}
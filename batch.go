@@ -0,0 +1,79 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+const maxBatchSize = 1000
+
+type BatchDeleteRequest struct {
+	IDs []string `json:"ids"`
+}
+
+type BatchDeleteResponse struct {
+	Deleted  []string `json:"deleted"`
+	NotFound []string `json:"notFound"`
+}
+
+// batchDeleteCatPics godoc
+// @Summary Delete several cat pictures
+// @Description Move a list of cat pictures to the trash in a single transaction and report which IDs were deleted and which were not found
+// @Tags catpics
+// @Accept  json
+// @Produce  json
+// @Param   request  body     BatchDeleteRequest   true  "IDs to delete"
+// @Success 200      {object} BatchDeleteResponse
+// @Failure 400      {object} Problem              "Invalid request"
+// @Failure 500      {object} Problem              "Internal Server Error"
+// @Router /v1/catpics:batchDelete [post]
+func BatchDeleteCatPics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req BatchDeleteRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		if len(req.IDs) == 0 {
+			writeProblem(w, r, problemInvalidBody, "No IDs given")
+			return
+		}
+
+		if len(req.IDs) > maxBatchSize {
+			writeProblem(w, r, problemInvalidBody, "Too many IDs")
+			return
+		}
+
+		tx, err := db.BeginTx(r.Context(), nil)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer tx.Rollback()
+
+		now := time.Now()
+		resp := BatchDeleteResponse{Deleted: []string{}, NotFound: []string{}}
+		for _, id := range req.IDs {
+			deleted, err := trashCatPic(traced(r.Context(), tx), id, now)
+			if err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+
+			if deleted {
+				resp.Deleted = append(resp.Deleted, id)
+			} else {
+				resp.NotFound = append(resp.NotFound, id)
+			}
+		}
+
+		if err := tx.Commit(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, resp, http.StatusOK)
+	}
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxTitleLength   = 200
+	maxCaptionLength = 2000
+	maxAltTextLength = 1000
+
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// CatPicPatch holds the text fields of a picture; fields left out of the
+// request are not changed.
+type CatPicPatch struct {
+	Title   *string `json:"title"`
+	Caption *string `json:"caption"`
+	AltText *string `json:"altText"`
+}
+
+type SearchResult struct {
+	CatPicResponse
+	Rank    float64 `json:"rank"`
+	Snippet string  `json:"snippet"`
+}
+
+// patchCatPic godoc
+// @Summary Edit a cat picture's text
+// @Description Change the title, caption and/or alt text of a cat picture without re-uploading the image
+// @Tags catpics
+// @Accept  json
+// @Produce  json
+// @Param   id     path  string       true  "Cat Picture ID"
+// @Param   patch  body  CatPicPatch  true  "Fields to change"
+// @Success 200 {object} CatPicEnvelope
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id} [patch]
+func PatchCatPic(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var patch CatPicPatch
+		if err := decodeJSONBody(w, r, &patch); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		var sets []string
+		var args []interface{}
+		for _, field := range []struct {
+			name   string
+			column string
+			value  *string
+			max    int
+		}{
+			{"title", "title", patch.Title, maxTitleLength},
+			{"caption", "caption", patch.Caption, maxCaptionLength},
+			{"altText", "alt_text", patch.AltText, maxAltTextLength},
+		} {
+			if field.value == nil {
+				continue
+			}
+			if len(*field.value) > field.max {
+				writeProblem(w, r, problemInvalidBody, field.name+" must be at most "+strconv.Itoa(field.max)+" bytes")
+				return
+			}
+			sets = append(sets, field.column+" = ?")
+			args = append(args, strings.TrimSpace(*field.value))
+		}
+
+		var pic CatPicResponse
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			if len(sets) > 0 {
+				if _, err := q.Exec("UPDATE cat_pics SET "+strings.Join(sets, ", ")+" WHERE id = ? AND deleted_at IS NULL", append(args, id)...); err != nil {
+					return err
+				}
+				if err := indexCatPic(q, id); err != nil {
+					return err
+				}
+			}
+			var err error
+			pic, err = scanCatPicResponse(q.QueryRow("SELECT "+catPicResponseColumns+" FROM cat_pics p WHERE p.id = ? AND p.deleted_at IS NULL", id))
+			return err
+		})
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+		case err != nil:
+			serverError(w, r, "Server error", err)
+		default:
+			writeCatPic(w, r, pic, http.StatusOK)
+		}
+	}
+}
+
+// searchCatPics godoc
+// @Summary Search cat pictures
+// @Description Full-text search over titles, captions and alt text, best matches first. Matching words in the snippet are wrapped in <mark> tags.
+// @Tags catpics
+// @Produce  json
+// @Param   q      query  string  true   "Search terms"
+// @Param   limit  query  int     false  "Maximum number of results (1-100, default 20)"
+// @Success 200 {array} SearchResult
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Failure 501 {object} Problem "Search is not available in this build"
+// @Router /v1/catpics/search [get]
+func SearchCatPics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := ftsQuery(r.URL.Query().Get("q"))
+		if query == "" {
+			writeProblem(w, r, problemInvalidParameter, "Missing search terms")
+			return
+		}
+
+		limit := defaultSearchLimit
+		if v := r.URL.Query().Get("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxSearchLimit {
+				writeProblem(w, r, problemInvalidParameter, "limit must be an integer between 1 and 100")
+				return
+			}
+			limit = n
+		}
+
+		if !fts5Enabled {
+			writeProblem(w, r, problemSearchUnavailable, "Full-text search is not available in this build")
+			return
+		}
+
+		// Titles weigh more than captions, which weigh more than alt text.
+		rows, err := traced(r.Context(), db).Query(`SELECT `+catPicResponseColumns+`,
+				bm25(cat_pics_fts, 0, 10, 5, 2) AS rank,
+				snippet(cat_pics_fts, -1, '<mark>', '</mark>', '…', 12)
+			FROM cat_pics_fts JOIN cat_pics p ON p.id = cat_pics_fts.id
+			WHERE cat_pics_fts MATCH ? AND p.deleted_at IS NULL
+			ORDER BY rank LIMIT ?`, query, limit)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		results := []SearchResult{}
+		for rows.Next() {
+			var res SearchResult
+			var err error
+			res.CatPicResponse, err = scanCatPicResponse(rows, &res.Rank, &res.Snippet)
+			if err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			results = append(results, res)
+		}
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, results, http.StatusOK)
+	}
+}
+
+// ftsQuery turns free text into an FTS5 query matching every word, quoting
+// each one so user input cannot use (or break) the FTS5 query syntax. The last
+// word also matches as a prefix to support search-as-you-type.
+func ftsQuery(q string) string {
+	words := strings.Fields(q)
+	for i, word := range words {
+		words[i] = `"` + strings.ReplaceAll(word, `"`, `""`) + `"`
+	}
+	if len(words) > 0 {
+		words[len(words)-1] += "*"
+	}
+	return strings.Join(words, " ")
+}
+
+// indexCatPic brings the full-text index entry of the picture id up to date,
+// removing it if the picture is gone. The index is maintained here rather
+// than by triggers so that builds without FTS5 can still write to cat_pics;
+// ensureSearchIndex rebuilds the index once such a build has opened the
+// database.
+func indexCatPic(q dbtx, id string) error {
+	if !fts5Enabled {
+		return nil
+	}
+	if _, err := q.Exec("DELETE FROM cat_pics_fts WHERE id = ?", id); err != nil {
+		return err
+	}
+	_, err := q.Exec("INSERT INTO cat_pics_fts (id, title, caption, alt_text) SELECT id, title, caption, alt_text FROM cat_pics WHERE id = ?", id)
+	return err
+}
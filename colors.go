@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+	"strings"
+)
+
+const (
+	// paletteSize is the number of dominant colours stored per picture.
+	paletteSize = 5
+	// placeholderSize bounds the sides of the thumbnail the palette and
+	// BlurHash are computed from, which keeps their cost independent of the
+	// picture's size.
+	placeholderSize = 32
+	// BlurHash components along x and y; 4x3 suits typical landscape photos.
+	blurHashX, blurHashY = 4, 3
+)
+
+// thumbnail shrinks img to fit in size x size, averaging a fixed grid of
+// samples per output pixel.
+func thumbnail(img image.Image, size int) *image.NRGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return image.NewNRGBA(image.Rect(0, 0, 0, 0))
+	}
+	tw, th := size, size
+	if w > h {
+		th = max(1, size*h/w)
+	} else {
+		tw = max(1, size*w/h)
+	}
+	tw, th = min(tw, w), min(th, h)
+
+	const samples = 4
+	cellW, cellH := float64(w)/float64(tw), float64(h)/float64(th)
+	dst := image.NewNRGBA(image.Rect(0, 0, tw, th))
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			var r, g, bl, a uint32
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					x := b.Min.X + int((float64(tx)+(float64(sx)+0.5)/samples)*cellW)
+					y := b.Min.Y + int((float64(ty)+(float64(sy)+0.5)/samples)*cellH)
+					c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+					r, g, bl, a = r+uint32(c.R), g+uint32(c.G), bl+uint32(c.B), a+uint32(c.A)
+				}
+			}
+			const n = samples * samples
+			dst.SetNRGBA(tx, ty, color.NRGBA{uint8(r / n), uint8(g / n), uint8(bl / n), uint8(a / n)})
+		}
+	}
+	return dst
+}
+
+// dominantColors returns up to n colours covering most of img, most common
+// first, as #rrggbb. Pixels are grouped into coarse buckets of similar colour
+// and each bucket is represented by the average of its pixels. Mostly
+// transparent pixels are ignored.
+func dominantColors(img *image.NRGBA, n int) []string {
+	type bucket struct {
+		key     int
+		count   int
+		r, g, b int
+	}
+	buckets := map[int]*bucket{}
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b, a := int(img.Pix[i]), int(img.Pix[i+1]), int(img.Pix[i+2]), img.Pix[i+3]
+		if a < 128 {
+			continue
+		}
+		key := r>>5<<6 | g>>5<<3 | b>>5
+		bk, ok := buckets[key]
+		if !ok {
+			bk = &bucket{key: key}
+			buckets[key] = bk
+		}
+		bk.count++
+		bk.r, bk.g, bk.b = bk.r+r, bk.g+g, bk.b+b
+	}
+
+	sorted := make([]*bucket, 0, len(buckets))
+	for _, bk := range buckets {
+		sorted = append(sorted, bk)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].count != sorted[j].count {
+			return sorted[i].count > sorted[j].count
+		}
+		return sorted[i].key < sorted[j].key
+	})
+
+	colors := []string{}
+	for _, bk := range sorted[:min(n, len(sorted))] {
+		colors = append(colors, fmt.Sprintf("#%02x%02x%02x", bk.r/bk.count, bk.g/bk.count, bk.b/bk.count))
+	}
+	return colors
+}
+
+// blurHash encodes img as a BlurHash (https://blurha.sh) with cx x cy
+// components: a short string clients decode into a blurred placeholder.
+func blurHash(img *image.NRGBA, cx, cy int) string {
+	w, h := img.Rect.Dx(), img.Rect.Dy()
+	if w == 0 || h == 0 {
+		return ""
+	}
+
+	// Linear RGB of every pixel, computed once for all components.
+	linear := make([][3]float64, w*h)
+	for i := range linear {
+		for c := 0; c < 3; c++ {
+			linear[i][c] = sRGBToLinear(img.Pix[i*4+c])
+		}
+	}
+
+	factors := make([][3]float64, 0, cx*cy)
+	for j := 0; j < cy; j++ {
+		for i := 0; i < cx; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for y := 0; y < h; y++ {
+				for x := 0; x < w; x++ {
+					basis := norm * math.Cos(math.Pi*float64(i*x)/float64(w)) * math.Cos(math.Pi*float64(j*y)/float64(h))
+					for c := 0; c < 3; c++ {
+						f[c] += basis * linear[y*w+x][c]
+					}
+				}
+			}
+			for c := range f {
+				f[c] /= float64(w * h)
+			}
+			factors = append(factors, f)
+		}
+	}
+
+	var sb strings.Builder
+	encode83(&sb, (cx-1)+(cy-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxValue := 1.0
+	if len(ac) > 0 {
+		actualMax := 0.0
+		for _, f := range ac {
+			for _, v := range f {
+				actualMax = math.Max(actualMax, math.Abs(v))
+			}
+		}
+		quantisedMax := int(math.Max(0, math.Min(82, math.Floor(actualMax*166-0.5))))
+		maxValue = float64(quantisedMax+1) / 166
+		encode83(&sb, quantisedMax, 1)
+	} else {
+		encode83(&sb, 0, 1)
+	}
+
+	encode83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		q := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxValue, 0.5)*9+9.5))))
+		}
+		encode83(&sb, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return sb.String()
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func encode83(sb *strings.Builder, value, length int) {
+	for i := length - 1; i >= 0; i-- {
+		digit := value / int(math.Pow(83, float64(i))) % 83
+		sb.WriteByte(base83Chars[digit])
+	}
+}
+
+func sRGBToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
@@ -0,0 +1,190 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Dedup modes decide what CreateCatPic does with a picture whose bytes are
+// already stored.
+const (
+	// dedupRefCount stores the upload as a new picture sharing the existing blob.
+	dedupRefCount = "refcount"
+	// dedupReuse returns the ID of the existing picture instead of creating one.
+	dedupReuse = "reuse"
+)
+
+// Trace exporters decide where spans go.
+const (
+	traceExporterNone = "none"
+	// traceExporterOTLP sends spans to an OpenTelemetry collector over HTTP.
+	traceExporterOTLP   = "otlp"
+	traceExporterStdout = "stdout"
+)
+
+// Config holds the runtime settings of the service.
+type Config struct {
+	DedupMode string
+	// StripSensitiveExif removes GPS and other identifying EXIF tags from uploads.
+	StripSensitiveExif bool
+	// AutoOrient rotates uploads upright according to their EXIF orientation.
+	AutoOrient bool
+	// MaxImageWidth, MaxImageHeight and MaxImagePixels bound the dimensions of
+	// pictures that are accepted or decoded.
+	MaxImageWidth  int
+	MaxImageHeight int
+	MaxImagePixels int
+	// TrashRetention is how long deleted pictures stay restorable.
+	TrashRetention time.Duration
+	// MaxVersions is how many previous versions of each picture are kept.
+	MaxVersions int
+	// RequireIfMatch rejects updates and deletes without an If-Match header.
+	RequireIfMatch bool
+	// IdempotencyTTL is how long responses to uploads with an
+	// Idempotency-Key are kept for replay.
+	IdempotencyTTL time.Duration
+	// TimeOrderedIDs generates UUIDv7 IDs instead of random UUIDv4 ones.
+	TimeOrderedIDs bool
+	// TraceExporter is where traces are sent: none, otlp or stdout.
+	TraceExporter string
+	// MinFreeDiskMB is how much free space, in megabytes, the disk holding
+	// the database needs for the service to report itself ready.
+	MinFreeDiskMB int
+	// ShutdownDelay is how long the server keeps serving, while reporting
+	// itself not ready, after being asked to stop.
+	ShutdownDelay time.Duration
+	// LegacySunset is when the deprecated unversioned routes are due to be
+	// removed, as announced in their Sunset header.
+	LegacySunset time.Time
+}
+
+// config is the active configuration. main replaces it with loadConfig();
+// tests may tweak individual fields.
+var config = defaultConfig()
+
+func defaultConfig() Config {
+	return Config{
+		DedupMode:          dedupRefCount,
+		StripSensitiveExif: true,
+		MaxImageWidth:      16384,
+		MaxImageHeight:     16384,
+		MaxImagePixels:     40_000_000,
+		TrashRetention:     30 * 24 * time.Hour,
+		MaxVersions:        10,
+		IdempotencyTTL:     24 * time.Hour,
+		TraceExporter:      traceExporterNone,
+		MinFreeDiskMB:      100,
+		ShutdownDelay:      5 * time.Second,
+		LegacySunset:       legacyDeprecatedAt.AddDate(0, 6, 0),
+	}
+}
+
+// loadConfig reads the configuration from CATPICS_* environment variables,
+// falling back to the defaults for anything unset.
+func loadConfig() (Config, error) {
+	c := defaultConfig()
+
+	if v := os.Getenv("CATPICS_DEDUP_MODE"); v != "" {
+		if v != dedupRefCount && v != dedupReuse {
+			return c, fmt.Errorf("CATPICS_DEDUP_MODE must be %q or %q, got %q", dedupRefCount, dedupReuse, v)
+		}
+		c.DedupMode = v
+	}
+
+	if err := envBool("CATPICS_STRIP_EXIF", &c.StripSensitiveExif); err != nil {
+		return c, err
+	}
+	if err := envBool("CATPICS_AUTO_ORIENT", &c.AutoOrient); err != nil {
+		return c, err
+	}
+	for name, dst := range map[string]*int{
+		"CATPICS_MAX_IMAGE_WIDTH":  &c.MaxImageWidth,
+		"CATPICS_MAX_IMAGE_HEIGHT": &c.MaxImageHeight,
+		"CATPICS_MAX_IMAGE_PIXELS": &c.MaxImagePixels,
+	} {
+		if err := envInt(name, 1, dst); err != nil {
+			return c, err
+		}
+	}
+	if err := envDuration("CATPICS_TRASH_RETENTION", &c.TrashRetention); err != nil {
+		return c, err
+	}
+	if err := envInt("CATPICS_MAX_VERSIONS", 0, &c.MaxVersions); err != nil {
+		return c, err
+	}
+	if err := envBool("CATPICS_REQUIRE_IF_MATCH", &c.RequireIfMatch); err != nil {
+		return c, err
+	}
+	if err := envDuration("CATPICS_IDEMPOTENCY_TTL", &c.IdempotencyTTL); err != nil {
+		return c, err
+	}
+	if err := envBool("CATPICS_UUID_V7", &c.TimeOrderedIDs); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("CATPICS_TRACE_EXPORTER"); v != "" {
+		if v != traceExporterNone && v != traceExporterOTLP && v != traceExporterStdout {
+			return c, fmt.Errorf("CATPICS_TRACE_EXPORTER must be %q, %q or %q, got %q", traceExporterNone, traceExporterOTLP, traceExporterStdout, v)
+		}
+		c.TraceExporter = v
+	}
+	if err := envInt("CATPICS_MIN_FREE_DISK_MB", 0, &c.MinFreeDiskMB); err != nil {
+		return c, err
+	}
+	if err := envDuration("CATPICS_SHUTDOWN_DELAY", &c.ShutdownDelay); err != nil {
+		return c, err
+	}
+	if v := os.Getenv("CATPICS_LEGACY_SUNSET"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			return c, fmt.Errorf("CATPICS_LEGACY_SUNSET must be a date such as 2027-04-19, got %q", v)
+		}
+		c.LegacySunset = t
+	}
+
+	return c, nil
+}
+
+// envBool parses the named variable into dst if it is set.
+func envBool(name string, dst *bool) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	*dst = b
+	return nil
+}
+
+// envInt parses the named variable into dst if it is set, requiring it to be
+// at least min.
+func envInt(name string, min int, dst *int) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < min {
+		return fmt.Errorf("%s must be an integer of at least %d, got %q", name, min, v)
+	}
+	*dst = n
+	return nil
+}
+
+// envDuration parses the named variable, e.g. "720h", into dst if it is set.
+func envDuration(name string, dst *time.Duration) error {
+	v := os.Getenv(name)
+	if v == "" {
+		return nil
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return fmt.Errorf("%s must be a non-negative duration such as 720h, got %q", name, v)
+	}
+	*dst = d
+	return nil
+}
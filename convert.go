@@ -0,0 +1,180 @@
+package main
+
+import (
+	"bytes"
+	"container/list"
+	"errors"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"mime"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// convertibleTypes are the formats pictures can be transcoded to on read, in
+// order of preference when a client accepts several equally.
+var convertibleTypes = []string{"image/png", "image/jpeg", "image/gif"}
+
+// formatTypes maps the ?format= values to content types.
+var formatTypes = map[string]string{
+	"png":  "image/png",
+	"jpeg": "image/jpeg",
+	"jpg":  "image/jpeg",
+	"gif":  "image/gif",
+}
+
+// maxConvertedCacheBytes bounds the memory used by convertedImages.
+const maxConvertedCacheBytes = 64 << 20
+
+var convertedImages = newImageCache(maxConvertedCacheBytes)
+
+var errNotAcceptable = errors.New("no acceptable format")
+
+// negotiateType picks the content type to serve for a picture stored as
+// original, given the request's Accept header. The stored format wins ties so
+// that pictures are only transcoded when the client needs it.
+func negotiateType(accept, original string) (string, error) {
+	if strings.TrimSpace(accept) == "" {
+		return original, nil
+	}
+
+	best, bestQ := "", 0.0
+	for _, t := range append([]string{original}, convertibleTypes...) {
+		if q := acceptQuality(accept, t); q > bestQ {
+			best, bestQ = t, q
+		}
+	}
+	if best == "" {
+		return "", errNotAcceptable
+	}
+	return best, nil
+}
+
+// acceptQuality returns the q-value an Accept header gives contentType, taken
+// from the most specific media range that matches it.
+func acceptQuality(accept, contentType string) float64 {
+	q, specificity := 0.0, -1
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		var s int
+		switch {
+		case mediaType == contentType:
+			s = 2
+		case strings.HasSuffix(mediaType, "/*") && strings.HasPrefix(contentType, strings.TrimSuffix(mediaType, "*")):
+			s = 1
+		case mediaType == "*/*":
+			s = 0
+		default:
+			continue
+		}
+		if s <= specificity {
+			continue
+		}
+
+		specificity, q = s, 1
+		if v, ok := params["q"]; ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+				q = f
+			}
+		}
+	}
+	return q
+}
+
+// convertImage transcodes data into contentType, one of convertibleTypes.
+// quality only applies to JPEG output.
+func convertImage(data []byte, contentType string, quality int) ([]byte, error) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return nil, err
+	}
+	return encodeImage(img, contentType, quality)
+}
+
+// encodeImage encodes img as contentType, one of convertibleTypes.
+func encodeImage(img image.Image, contentType string, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+	switch contentType {
+	case "image/png":
+		err = png.Encode(&buf, img)
+	case "image/jpeg":
+		// JPEG has no alpha channel; flatten transparent areas onto white
+		// instead of letting them turn black.
+		flat := image.NewRGBA(img.Bounds())
+		draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+		draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+		err = jpeg.Encode(&buf, flat, &jpeg.Options{Quality: quality})
+	case "image/gif":
+		err = gif.Encode(&buf, img, nil)
+	default:
+		err = errors.New("unsupported format " + contentType)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// imageCache is a size-bounded LRU cache of converted pictures. Keys include
+// the content hash of the source, so entries never go stale.
+type imageCache struct {
+	mu       sync.Mutex
+	maxBytes int
+	size     int
+	order    *list.List // front is most recently used
+	entries  map[string]*list.Element
+}
+
+type imageCacheEntry struct {
+	key  string
+	data []byte
+}
+
+func newImageCache(maxBytes int) *imageCache {
+	return &imageCache{maxBytes: maxBytes, order: list.New(), entries: map[string]*list.Element{}}
+}
+
+func (c *imageCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*imageCacheEntry).data, true
+}
+
+func (c *imageCache) put(key string, data []byte) {
+	if len(data) > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.order.PushFront(&imageCacheEntry{key: key, data: data})
+	c.size += len(data)
+
+	for c.size > c.maxBytes {
+		oldest := c.order.Back()
+		entry := oldest.Value.(*imageCacheEntry)
+		c.order.Remove(oldest)
+		delete(c.entries, entry.key)
+		c.size -= len(entry.data)
+	}
+}
@@ -0,0 +1,15 @@
+//go:build linux || darwin || freebsd
+
+package main
+
+import "syscall"
+
+// diskFree returns the number of bytes available to unprivileged users on
+// the file system holding dir. ok is false where this cannot be determined.
+func diskFree(dir string) (free uint64, ok bool, err error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return 0, true, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), true, nil
+}
@@ -15,97 +15,174 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/catpics": {
+        "/healthz": {
             "get": {
-                "description": "Get a list of all cat pictures' metadata",
-                "consumes": [
+                "description": "Report that the process is up and serving requests. Does not look at the database.",
+                "produces": [
                     "application/json"
                 ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Liveness check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/problems/{code}": {
+            "get": {
+                "description": "Describe the problem type an error response's type URI names",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "catpics"
+                    "errors"
                 ],
-                "summary": "List all cat pictures",
+                "summary": "Describe an error type",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Problem code",
+                        "name": "code",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Unknown problem type",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Report whether the service can take traffic: the database answers, all migrations are applied, picture storage is writable and the disk has enough free space. Fails while the server is shutting down.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "health"
+                ],
+                "summary": "Readiness check",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthStatus"
+                        }
+                    },
+                    "503": {
+                        "description": "Not ready",
+                        "schema": {
+                            "$ref": "#/definitions/main.HealthStatus"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/albums": {
+            "get": {
+                "description": "List every album with its pictures in order",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "albums"
+                ],
+                "summary": "List albums",
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
                             "type": "array",
                             "items": {
-                                "$ref": "#/definitions/main.CatPicInfo"
+                                "$ref": "#/definitions/main.Album"
                             }
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             },
             "post": {
-                "description": "Add a new cat picture to the collection",
+                "description": "Create an album, optionally with an ordered list of pictures",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "catpics"
+                    "albums"
                 ],
-                "summary": "Create a cat picture",
+                "summary": "Create an album",
                 "parameters": [
                     {
-                        "type": "file",
-                        "description": "Cat Picture",
-                        "name": "catpic",
-                        "in": "formData",
-                        "required": true
+                        "description": "Album",
+                        "name": "album",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.AlbumRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "201": {
                         "description": "Created",
                         "schema": {
-                            "$ref": "#/definitions/main.CatPic"
+                            "$ref": "#/definitions/main.Album"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Invalid request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
         },
-        "/catpics/{id}": {
+        "/v1/albums/{id}": {
             "get": {
-                "description": "Get a cat picture by its unique ID",
-                "consumes": [
-                    "application/json"
-                ],
+                "description": "Get an album with its pictures in order",
                 "produces": [
-                    "image/jpeg"
+                    "application/json"
                 ],
                 "tags": [
-                    "catpics"
+                    "albums"
                 ],
-                "summary": "Get a cat picture by ID",
+                "summary": "Get an album",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Cat Picture ID",
+                        "description": "Album ID",
                         "name": "id",
                         "in": "path",
                         "required": true
@@ -115,86 +192,117 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/main.CatPicResponse"
+                            "$ref": "#/definitions/main.Album"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             },
             "put": {
-                "description": "Update an existing cat picture with new image data",
+                "description": "Replace an album's name and ordered picture list",
                 "consumes": [
-                    "multipart/form-data"
+                    "application/json"
                 ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
-                    "catpics"
+                    "albums"
                 ],
-                "summary": "Update a cat picture",
+                "summary": "Update an album",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Cat Picture ID",
+                        "description": "Album ID",
                         "name": "id",
                         "in": "path",
                         "required": true
                     },
                     {
-                        "type": "file",
-                        "description": "New Cat Picture",
-                        "name": "catpic",
-                        "in": "formData",
-                        "required": true
+                        "description": "Album",
+                        "name": "album",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.AlbumRequest"
+                        }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "ok",
+                        "description": "OK",
                         "schema": {
-                            "type": "string"
+                            "$ref": "#/definitions/main.Album"
                         }
                     },
                     "400": {
-                        "description": "Bad Request",
+                        "description": "Invalid request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             },
             "delete": {
-                "description": "Delete a cat picture by its unique identifier",
+                "description": "Delete an album. The pictures in it are kept.",
+                "tags": [
+                    "albums"
+                ],
+                "summary": "Delete an album",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Album ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/albums/{id}/pictures": {
+            "post": {
+                "description": "Append a cat picture to the end of an album",
                 "consumes": [
                     "application/json"
                 ],
@@ -202,75 +310,2008 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "catpics"
+                    "albums"
                 ],
-                "summary": "Delete a cat picture",
+                "summary": "Add a picture to an album",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Cat Picture ID",
+                        "description": "Album ID",
                         "name": "id",
                         "in": "path",
                         "required": true
+                    },
+                    {
+                        "description": "Cat Picture",
+                        "name": "picture",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.AlbumPictureRequest"
+                        }
                     }
                 ],
                 "responses": {
-                    "204": {
-                        "description": "No Content"
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.Album"
+                        }
                     },
                     "400": {
                         "description": "Invalid request",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "404": {
                         "description": "Not Found",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     },
                     "500": {
                         "description": "Internal Server Error",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": {
-                                "type": "string"
-                            }
+                            "$ref": "#/definitions/main.Problem"
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "main.CatPic": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "type": "string"
-                }
-            }
         },
-        "main.CatPicInfo": {
-            "type": "object",
-            "properties": {
-                "id": {
-                    "type": "string"
+        "/v1/albums/{id}/pictures/{pictureId}": {
+            "delete": {
+                "description": "Remove a cat picture from an album. The picture itself is kept.",
+                "tags": [
+                    "albums"
+                ],
+                "summary": "Remove a picture from an album",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Album ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "pictureId",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
                 }
             }
         },
-        "main.CatPicResponse": {
-            "type": "object",
-            "properties": {
-                "id": {
+        "/v1/catpics": {
+            "get": {
+                "description": "Get a list of all cat pictures' metadata",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "List all cat pictures",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of at most 1000 IDs to include",
+                        "name": "ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only pictures carrying every given tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only pictures in the given album",
+                        "name": "album",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicListEnvelope"
+                        }
+                    },
+                    "400": {
+                        "description": "Too many IDs",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Add a new cat picture to the collection. Clients that retry uploads should send an Idempotency-Key: retries with the same key and request get the original response, headers included (marked with Idempotent-Replayed: true), for CATPICS_IDEMPOTENCY_TTL instead of creating another picture.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Create a cat picture",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Cat Picture",
+                        "name": "catpic",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Tags to attach (repeated or comma-separated)",
+                        "name": "tags",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Unique key for this upload, at most 255 characters",
+                        "name": "Idempotency-Key",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Identical picture already stored (CATPICS_DEDUP_MODE=reuse)",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Picture version, for If-Match"
+                            }
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Picture version, for If-Match"
+                            },
+                            "Location": {
+                                "type": "string",
+                                "description": "Path of the new picture"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Image dimensions exceed the configured limits, or the Idempotency-Key was used for a different request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/export.zip": {
+            "get": {
+                "description": "Stream a ZIP archive of all (or filtered) cat pictures together with a JSON manifest",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Export cat pictures as a ZIP archive",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Comma-separated list of at most 1000 IDs to include",
+                        "name": "ids",
+                        "in": "query"
+                    },
+                    {
+                        "type": "array",
+                        "items": {
+                            "type": "string"
+                        },
+                        "collectionFormat": "multi",
+                        "description": "Only pictures carrying every given tag",
+                        "name": "tag",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Only pictures in the given album",
+                        "name": "album",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "ZIP archive",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Too many IDs",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/import": {
+            "post": {
+                "description": "Import every picture in a ZIP or tar.gz archive, such as one produced by the export endpoint. Entries named after a valid picture ID keep that ID.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Import cat pictures from an archive",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "ZIP or tar.gz archive",
+                        "name": "archive",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.ImportReport"
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "Request Entity Too Large",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/search": {
+            "get": {
+                "description": "Full-text search over titles, captions and alt text, best matches first. Matching words in the snippet are wrapped in \u003cmark\u003e tags.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Search cat pictures",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Search terms",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum number of results (1-100, default 20)",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SearchResult"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "501": {
+                        "description": "Search is not available in this build",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}": {
+            "get": {
+                "description": "Get the metadata of a cat picture, with links to the image and the picture's versions and tags. The response carries no ETag, as the picture's version only changes with its image; use the version field for If-Match.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Get a cat picture's metadata",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "put": {
+                "description": "Replace the image data of a cat picture, or create the picture under the given ID if there is none, so clients can choose IDs. IDs are 1 to 64 letters, digits, - and _. Send the ETag from GET /v1/catpics/{id} in If-Match to make sure nobody else updated the picture in the meantime, or If-None-Match: * to only create it; CATPICS_REQUIRE_IF_MATCH makes one of them mandatory.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Create or update a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "New Cat Picture",
+                        "name": "catpic",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag or version number the picture must still have",
+                        "name": "If-Match",
+                        "in": "header"
+                    },
+                    {
+                        "type": "string",
+                        "description": "* to fail if the picture exists",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "Updated",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "ETag of the new version"
+                            }
+                        }
+                    },
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "ETag of the new version"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "A picture with this ID is in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "A precondition failed",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "413": {
+                        "description": "File too large",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Image dimensions exceed the configured limits",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match is required",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Move a cat picture to the trash. It can be restored until it is purged after the retention period (CATPICS_TRASH_RETENTION). If-Match works as for updates.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Delete a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag or version number the picture must still have",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "The picture no longer matches If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match is required",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "patch": {
+                "description": "Change the title, caption and/or alt text of a cat picture without re-uploading the image",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Edit a cat picture's text",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Fields to change",
+                        "name": "patch",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicPatch"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/data": {
+            "get": {
+                "description": "Get a cat picture by its unique ID. The picture is served in its stored format unless the Accept header or the format parameter asks for another one, in which case it is transcoded.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "image/jpeg",
+                    "image/png",
+                    "image/gif"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Get a cat picture by ID",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "enum": [
+                            "png",
+                            "jpeg",
+                            "gif"
+                        ],
+                        "type": "string",
+                        "description": "Format to convert to, overriding the Accept header",
+                        "name": "format",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "JPEG quality (1-100, default 90)",
+                        "name": "quality",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "Acceptable image types, e.g. image/png",
+                        "name": "Accept",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        },
+                        "headers": {
+                            "ETag": {
+                                "type": "string",
+                                "description": "Picture version, for If-Match; only set when the stored format is served"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid format or quality",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "406": {
+                        "description": "None of the accepted formats can be produced",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Picture cannot be decoded for conversion or exceeds the dimension limits",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/poster": {
+            "get": {
+                "description": "Return the first frame of an animated GIF as a static PNG. Other pictures are returned as PNG unchanged.",
+                "produces": [
+                    "image/png"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Get a still preview of a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Picture is not a decodable image or exceeds the dimension limits",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/restore": {
+            "post": {
+                "description": "Take a cat picture out of the trash",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Restore a deleted cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicEnvelope"
+                        }
+                    },
+                    "404": {
+                        "description": "Not in the trash",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/similar": {
+            "get": {
+                "description": "List pictures whose perceptual hash is within maxDistance bits of the given picture's, closest first",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Find similar cat pictures",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Maximum Hamming distance (0-32, default 10)",
+                        "name": "maxDistance",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.SimilarCatPic"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "Bad Request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Picture is not a decodable image",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/tags": {
+            "get": {
+                "description": "List the tags attached to a cat picture",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "List a picture's tags",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "type": "string"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/tags/{tag}": {
+            "put": {
+                "description": "Attach a tag to a cat picture, creating the tag if it does not exist yet",
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Tag a picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag name",
+                        "name": "tag",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid tag name",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Detach a tag from a cat picture",
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Untag a picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Tag name",
+                        "name": "tag",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "400": {
+                        "description": "Invalid tag name",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/transform": {
+            "get": {
+                "description": "Apply a pipeline of image operations, separated by |, and return the result in the picture's stored format (PNG for formats other than JPEG). Operations: crop:x,y,width,height; rotate:90|180|270 (clockwise); flip:h|v; grayscale; blur:radius (1-20). At most 10 operations are allowed and the total work is bounded. Responses carry an ETag derived from the picture and the operations.",
+                "produces": [
+                    "image/jpeg",
+                    "image/png"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Transform a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Operations, e.g. crop:10,10,200,200|rotate:90|grayscale",
+                        "name": "ops",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag of a cached result",
+                        "name": "If-None-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "304": {
+                        "description": "Cached result is still valid"
+                    },
+                    "400": {
+                        "description": "Invalid operations",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "422": {
+                        "description": "Picture is not a decodable image, exceeds the dimension limits, or the operations are too expensive",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/versions": {
+            "get": {
+                "description": "List the current version of a picture followed by the previous ones kept by updates, newest first. At most CATPICS_MAX_VERSIONS previous versions are kept.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "List the versions of a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.CatPicVersion"
+                            }
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/versions/{n}": {
+            "get": {
+                "description": "Get the bytes of the current or a previous version of a picture",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Get a version of a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Version number",
+                        "name": "n",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid version",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics/{id}/versions/{n}/restore": {
+            "post": {
+                "description": "Make a previous version the picture's bytes again. This is an update like any other, so the version it replaces is kept in the history and the restored bytes get a new version number. If-Match works as for updates.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Restore a previous version of a cat picture",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Cat Picture ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Version number",
+                        "name": "n",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "ETag or version number the picture must still have",
+                        "name": "If-Match",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.CatPicVersion"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid version",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Already the current version",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "412": {
+                        "description": "The picture no longer matches If-Match",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "428": {
+                        "description": "If-Match is required",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/catpics:batchDelete": {
+            "post": {
+                "description": "Move a list of cat pictures to the trash in a single transaction and report which IDs were deleted and which were not found",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "Delete several cat pictures",
+                "parameters": [
+                    {
+                        "description": "IDs to delete",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchDeleteRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.BatchDeleteResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tags": {
+            "get": {
+                "description": "List every tag together with the number of pictures carrying it",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "List tags",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.Tag"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "description": "Create a new, unused tag",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Create a tag",
+                "parameters": [
+                    {
+                        "description": "Tag",
+                        "name": "tag",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TagRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "201": {
+                        "description": "Created",
+                        "schema": {
+                            "$ref": "#/definitions/main.Tag"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Tag already exists",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/tags/{name}": {
+            "put": {
+                "description": "Rename a tag, keeping its pictures",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Rename a tag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "New name",
+                        "name": "tag",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/main.TagRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/main.TagRequest"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "409": {
+                        "description": "Tag already exists",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a tag and remove it from every picture",
+                "tags": [
+                    "tags"
+                ],
+                "summary": "Delete a tag",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Tag name",
+                        "name": "name",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    },
+                    "404": {
+                        "description": "Not Found",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/trash": {
+            "get": {
+                "description": "List the pictures in the trash, most recently deleted first, with the time each will be purged",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "catpics"
+                ],
+                "summary": "List deleted cat pictures",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/main.TrashedCatPic"
+                            }
+                        }
+                    },
+                    "500": {
+                        "description": "Internal Server Error",
+                        "schema": {
+                            "$ref": "#/definitions/main.Problem"
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "main.Album": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "pictures": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.AlbumPictureRequest": {
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.AlbumRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                },
+                "pictures": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.Animation": {
+            "type": "object",
+            "properties": {
+                "durationMs": {
+                    "description": "DurationMs is the time one pass over all frames takes.",
+                    "type": "integer"
+                },
+                "frames": {
+                    "type": "integer"
+                },
+                "loopCount": {
+                    "description": "LoopCount follows image/gif: 0 loops forever, -1 plays once and n \u003e 0\nrepeats n times after the first pass.",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.BatchDeleteRequest": {
+            "type": "object",
+            "properties": {
+                "ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.BatchDeleteResponse": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "notFound": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "main.CatPicEnvelope": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "$ref": "#/definitions/main.CatPicResource"
+                }
+            }
+        },
+        "main.CatPicLinks": {
+            "type": "object",
+            "properties": {
+                "content": {
+                    "description": "Content serves the image itself.",
+                    "type": "string",
+                    "example": "/v1/catpics/c4t/data"
+                },
+                "self": {
+                    "type": "string",
+                    "example": "/v1/catpics/c4t"
+                },
+                "tags": {
+                    "type": "string",
+                    "example": "/v1/catpics/c4t/tags"
+                },
+                "versions": {
+                    "type": "string",
+                    "example": "/v1/catpics/c4t/versions"
+                }
+            }
+        },
+        "main.CatPicListEnvelope": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.CatPicResource"
+                    }
+                },
+                "links": {
+                    "$ref": "#/definitions/main.ListLinks"
+                }
+            }
+        },
+        "main.CatPicPatch": {
+            "type": "object",
+            "properties": {
+                "altText": {
+                    "type": "string"
+                },
+                "caption": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.CatPicResource": {
+            "type": "object",
+            "properties": {
+                "altText": {
+                    "type": "string"
+                },
+                "animation": {
+                    "description": "Animation is only set for GIFs.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Animation"
+                        }
+                    ]
+                },
+                "blurHash": {
+                    "type": "string"
+                },
+                "camera": {
+                    "type": "string"
+                },
+                "caption": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "links": {
+                    "$ref": "#/definitions/main.CatPicLinks"
+                },
+                "orientation": {
+                    "type": "integer"
+                },
+                "palette": {
+                    "description": "Palette lists the dominant colours as #rrggbb, most common first.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "takenAt": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version counts the picture's uploads; its ETag is the quoted version.",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.CatPicVersion": {
+            "type": "object",
+            "properties": {
+                "current": {
+                    "type": "boolean"
+                },
+                "replacedAt": {
+                    "description": "ReplacedAt is when a previous version was superseded; empty for the\ncurrent one.",
+                    "type": "string"
+                },
+                "size": {
+                    "type": "integer"
+                },
+                "version": {
+                    "type": "integer"
+                }
+            }
+        },
+        "main.HealthCheck": {
+            "type": "object",
+            "properties": {
+                "detail": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.HealthStatus": {
+            "type": "object",
+            "properties": {
+                "checks": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/main.HealthCheck"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ImportFailure": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "file": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ImportReport": {
+            "type": "object",
+            "properties": {
+                "failed": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportFailure"
+                    }
+                },
+                "imported": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/main.ImportedCatPic"
+                    }
+                }
+            }
+        },
+        "main.ImportedCatPic": {
+            "type": "object",
+            "properties": {
+                "file": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.ListLinks": {
+            "type": "object",
+            "properties": {
+                "self": {
+                    "type": "string",
+                    "example": "/v1/catpics?tag=tabby"
+                }
+            }
+        },
+        "main.Problem": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "description": "Code is the stable, machine-readable name of the problem type.",
+                    "type": "string",
+                    "example": "catpic-not-found"
+                },
+                "detail": {
+                    "description": "Detail explains this occurrence of the problem.",
+                    "type": "string",
+                    "example": "Cat picture not found"
+                },
+                "instance": {
+                    "description": "Instance is the path of the request that failed.",
+                    "type": "string",
+                    "example": "/catpics/c4t"
+                },
+                "status": {
+                    "description": "Status repeats the HTTP status code of the response.",
+                    "type": "integer",
+                    "example": 404
+                },
+                "title": {
+                    "type": "string",
+                    "example": "Cat picture not found"
+                },
+                "type": {
+                    "description": "Type identifies the kind of problem and is the same for every\noccurrence of it.",
+                    "type": "string",
+                    "example": "/problems/catpic-not-found"
+                }
+            }
+        },
+        "main.SearchResult": {
+            "type": "object",
+            "properties": {
+                "altText": {
+                    "type": "string"
+                },
+                "animation": {
+                    "description": "Animation is only set for GIFs.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Animation"
+                        }
+                    ]
+                },
+                "blurHash": {
+                    "type": "string"
+                },
+                "camera": {
+                    "type": "string"
+                },
+                "caption": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "orientation": {
+                    "type": "integer"
+                },
+                "palette": {
+                    "description": "Palette lists the dominant colours as #rrggbb, most common first.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "rank": {
+                    "type": "number"
+                },
+                "snippet": {
+                    "type": "string"
+                },
+                "takenAt": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                },
+                "version": {
+                    "description": "Version counts the picture's uploads; its ETag is the quoted version.",
+                    "type": "integer"
+                }
+            }
+        },
+        "main.SimilarCatPic": {
+            "type": "object",
+            "properties": {
+                "distance": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.Tag": {
+            "type": "object",
+            "properties": {
+                "count": {
+                    "type": "integer"
+                },
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TagRequest": {
+            "type": "object",
+            "properties": {
+                "name": {
+                    "type": "string"
+                }
+            }
+        },
+        "main.TrashedCatPic": {
+            "type": "object",
+            "properties": {
+                "altText": {
+                    "type": "string"
+                },
+                "animation": {
+                    "description": "Animation is only set for GIFs.",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/main.Animation"
+                        }
+                    ]
+                },
+                "blurHash": {
+                    "type": "string"
+                },
+                "camera": {
+                    "type": "string"
+                },
+                "caption": {
+                    "type": "string"
+                },
+                "deletedAt": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "orientation": {
+                    "type": "integer"
+                },
+                "palette": {
+                    "description": "Palette lists the dominant colours as #rrggbb, most common first.",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "purgeAt": {
+                    "description": "PurgeAt is when the picture will be deleted permanently.",
+                    "type": "string"
+                },
+                "takenAt": {
+                    "type": "string"
+                },
+                "title": {
                     "type": "string"
+                },
+                "version": {
+                    "description": "Version counts the picture's uploads; its ETag is the quoted version.",
+                    "type": "integer"
                 }
             }
         }
@@ -284,7 +2325,7 @@ var SwaggerInfo = &swag.Spec{
 	BasePath:         "/",
 	Schemes:          []string{},
 	Title:            "Cat Pics API",
-	Description:      "This is a simple set of API's to store and retrieve cat pictures.",
+	Description:      "This is a simple set of API's to store and retrieve cat pictures.\nErrors are reported as RFC 7807 problem details (application/problem+json) with a stable code, described at /problems/{code}.\nThe unversioned paths of earlier releases (e.g. /catpics instead of /v1/catpics) still work but are deprecated and announce their removal in a Sunset header. They return bare objects rather than the /v1 envelope, and GET /catpics/{id} serves the image itself.",
 	InfoInstanceName: "swagger",
 	SwaggerTemplate:  docTemplate,
 	LeftDelim:        "{{",
@@ -0,0 +1,522 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"strings"
+	"time"
+)
+
+// EXIF tags read or scrubbed by this file.
+const (
+	tagMake             = 0x010F
+	tagModel            = 0x0110
+	tagOrientation      = 0x0112
+	tagDateTime         = 0x0132
+	tagArtist           = 0x013B
+	tagHostComputer     = 0x013C
+	tagExifIFD          = 0x8769
+	tagGPSIFD           = 0x8825
+	tagDateTimeOriginal = 0x9003
+	tagOffsetTimeOrig   = 0x9011
+	tagMakerNote        = 0x927C
+	tagUserComment      = 0x9286
+	tagXPComment        = 0x9C9C
+	tagXPAuthor         = 0x9C9D
+	tagImageUniqueID    = 0xA420
+	tagCameraOwnerName  = 0xA430
+	tagBodySerialNumber = 0xA431
+	tagLensSerialNumber = 0xA435
+)
+
+// sensitiveExifTags identify the photographer, their device or their
+// whereabouts. The GPS IFD is removed together with its pointer; MakerNote is
+// dropped because vendors store serial numbers and locations in it.
+var sensitiveExifTags = map[uint16]bool{
+	tagArtist:           true,
+	tagHostComputer:     true,
+	tagGPSIFD:           true,
+	tagMakerNote:        true,
+	tagUserComment:      true,
+	tagXPComment:        true,
+	tagXPAuthor:         true,
+	tagImageUniqueID:    true,
+	tagCameraOwnerName:  true,
+	tagBodySerialNumber: true,
+	tagLensSerialNumber: true,
+}
+
+// exifInfo holds the EXIF fields kept as picture metadata.
+type exifInfo struct {
+	Camera      string
+	TakenAt     string
+	Orientation int
+}
+
+// exifBlock locates the TIFF-structured EXIF payload inside a JPEG APP1
+// segment or a PNG eXIf chunk.
+type exifBlock struct {
+	tiff       []byte // aliases the file bytes
+	tiffStart  int    // offset of tiff within the file
+	start, end int    // span of the whole segment or chunk
+	png        bool
+}
+
+// findExif returns the EXIF block of a JPEG or PNG file.
+func findExif(data []byte) (exifBlock, bool) {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return findJPEGExif(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return findPNGExif(data)
+	}
+	return exifBlock{}, false
+}
+
+func findJPEGExif(data []byte) (exifBlock, bool) {
+	for i := 2; i+4 <= len(data); {
+		if data[i] != 0xFF {
+			return exifBlock{}, false
+		}
+		marker := data[i+1]
+		switch {
+		case marker == 0xFF:
+			i++ // fill byte
+			continue
+		case marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7):
+			i += 2
+			continue
+		case marker == 0xDA || marker == 0xD9:
+			// Metadata segments all come before the scan data.
+			return exifBlock{}, false
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(data[i+2:]))
+		if end > len(data) || end < i+4 {
+			return exifBlock{}, false
+		}
+		payload := data[i+4 : end]
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")) {
+			return exifBlock{tiff: payload[6:], tiffStart: i + 10, start: i, end: end}, true
+		}
+		i = end
+	}
+	return exifBlock{}, false
+}
+
+func findPNGExif(data []byte) (exifBlock, bool) {
+	for i := 8; i+12 <= len(data); {
+		length := int(binary.BigEndian.Uint32(data[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(data) {
+			return exifBlock{}, false
+		}
+		switch string(data[i+4 : i+8]) {
+		case "eXIf":
+			return exifBlock{tiff: data[i+8 : i+8+length], tiffStart: i + 8, start: i, end: end, png: true}, true
+		case "IDAT", "IEND":
+			return exifBlock{}, false
+		}
+		i = end
+	}
+	return exifBlock{}, false
+}
+
+// readExif extracts the camera, capture time and orientation from data.
+func readExif(data []byte) exifInfo {
+	var info exifInfo
+
+	block, ok := findExif(data)
+	if !ok {
+		return info
+	}
+	t, ok := newTIFF(block.tiff)
+	if !ok {
+		return info
+	}
+
+	ifd0 := t.entries(t.firstIFD())
+	maker := strings.TrimSpace(t.ascii(ifd0[tagMake]))
+	model := strings.TrimSpace(t.ascii(ifd0[tagModel]))
+	if maker != "" && !strings.HasPrefix(strings.ToLower(model), strings.ToLower(maker)) {
+		info.Camera = strings.TrimSpace(maker + " " + model)
+	} else {
+		info.Camera = model
+	}
+
+	if o, ok := t.short(ifd0[tagOrientation]); ok && o >= 1 && o <= 8 {
+		info.Orientation = int(o)
+	}
+
+	taken := t.ascii(ifd0[tagDateTime])
+	var offset string
+	if ptr, ok := t.long(ifd0[tagExifIFD]); ok {
+		exifIFD := t.entries(ptr)
+		if v := t.ascii(exifIFD[tagDateTimeOriginal]); v != "" {
+			taken = v
+			offset = t.ascii(exifIFD[tagOffsetTimeOrig])
+		}
+	}
+	if ts, err := time.Parse("2006:01:02 15:04:05", strings.TrimSpace(taken)); err == nil {
+		info.TakenAt = ts.Format("2006-01-02T15:04:05")
+		if _, err := time.Parse("-07:00", offset); err == nil {
+			info.TakenAt += offset
+		}
+	}
+
+	return info
+}
+
+// xmpPrefixes start the payload of the JPEG APP1 segments holding an XMP
+// packet or the continuation of one too large for a single segment.
+var xmpPrefixes = [][]byte{
+	[]byte("http://ns.adobe.com/xap/1.0/\x00"),
+	[]byte("http://ns.adobe.com/xmp/extension/\x00"),
+}
+
+// stripSensitiveExif returns data with the GPS block and other identifying
+// EXIF tags removed from every EXIF block, and XMP packets, which repeat the
+// same information, dropped. Everything else in the EXIF blocks, including
+// orientation and thumbnails, is kept. An EXIF block that cannot be parsed is
+// dropped entirely.
+func stripSensitiveExif(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return stripJPEGMetadata(data)
+	case bytes.HasPrefix(data, []byte("\x89PNG\r\n\x1a\n")):
+		return stripPNGMetadata(data)
+	}
+	return data
+}
+
+func stripJPEGMetadata(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	var drop [][2]int
+	for i := 2; i+4 <= len(out); {
+		if out[i] != 0xFF {
+			break
+		}
+		marker := out[i+1]
+		if marker == 0xFF {
+			i++ // fill byte
+			continue
+		}
+		if marker == 0xD8 || marker == 0x01 || (marker >= 0xD0 && marker <= 0xD7) {
+			i += 2
+			continue
+		}
+		if marker == 0xDA || marker == 0xD9 {
+			// Metadata segments all come before the scan data.
+			break
+		}
+
+		end := i + 2 + int(binary.BigEndian.Uint16(out[i+2:]))
+		if end > len(out) || end < i+4 {
+			break
+		}
+		payload := out[i+4 : end]
+		switch {
+		case marker == 0xE1 && bytes.HasPrefix(payload, []byte("Exif\x00\x00")):
+			if !scrubTIFF(payload[6:]) {
+				drop = append(drop, [2]int{i, end})
+			}
+		case marker == 0xE1 && isXMP(payload):
+			drop = append(drop, [2]int{i, end})
+		}
+		i = end
+	}
+	return removeSpans(out, drop)
+}
+
+func stripPNGMetadata(data []byte) []byte {
+	out := append([]byte(nil), data...)
+	var drop [][2]int
+	for i := 8; i+12 <= len(out); {
+		length := int(binary.BigEndian.Uint32(out[i:]))
+		end := i + 12 + length
+		if length < 0 || end > len(out) {
+			break
+		}
+		chunk := out[i+8 : i+8+length]
+		switch string(out[i+4 : i+8]) {
+		case "eXIf":
+			if !scrubTIFF(chunk) {
+				drop = append(drop, [2]int{i, end})
+				break
+			}
+			// The chunk CRC covers the chunk type and data.
+			binary.BigEndian.PutUint32(out[end-4:], crc32.ChecksumIEEE(out[i+4:end-4]))
+		case "iTXt", "tEXt", "zTXt":
+			if bytes.HasPrefix(chunk, []byte("XML:com.adobe.xmp\x00")) {
+				drop = append(drop, [2]int{i, end})
+			}
+		case "IEND":
+			return removeSpans(out, drop)
+		}
+		i = end
+	}
+	return removeSpans(out, drop)
+}
+
+// scrubTIFF removes the sensitive tags from the TIFF-structured EXIF data b
+// in place. It reports false if b cannot be parsed.
+func scrubTIFF(b []byte) bool {
+	t, ok := newTIFF(b)
+	if !ok {
+		return false
+	}
+	ifd0 := t.firstIFD()
+	if ptr, ok := t.long(t.entries(ifd0)[tagExifIFD]); ok {
+		t.removeEntries(ptr, sensitiveExifTags)
+	}
+	t.removeEntries(ifd0, sensitiveExifTags)
+	return true
+}
+
+func isXMP(payload []byte) bool {
+	for _, prefix := range xmpPrefixes {
+		if bytes.HasPrefix(payload, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeSpans returns b without the given ascending, non-overlapping spans.
+func removeSpans(b []byte, spans [][2]int) []byte {
+	if len(spans) == 0 {
+		return b
+	}
+	out := make([]byte, 0, len(b))
+	at := 0
+	for _, span := range spans {
+		out = append(out, b[at:span[0]]...)
+		at = span[1]
+	}
+	return append(out, b[at:]...)
+}
+
+// servedImage returns stored picture bytes as the API serves them. Pictures
+// stored before EXIF stripping covered every block and XMP, or while it was
+// turned off, are scrubbed on the way out rather than rewritten in storage.
+func servedImage(data []byte) []byte {
+	if !config.StripSensitiveExif {
+		return data
+	}
+	return stripSensitiveExif(data)
+}
+
+// withExif returns a copy of an encoded JPEG or PNG file with tiff embedded as
+// its EXIF block, placed right after the SOI marker or IHDR chunk.
+func withExif(data, tiff []byte, png bool) []byte {
+	var block []byte
+	var at int
+	if png {
+		block = binary.BigEndian.AppendUint32(nil, uint32(len(tiff)))
+		block = append(block, "eXIf"...)
+		block = append(block, tiff...)
+		block = binary.BigEndian.AppendUint32(block, crc32.ChecksumIEEE(block[4:]))
+		at = 8 + 12 + int(binary.BigEndian.Uint32(data[8:]))
+	} else {
+		if len(tiff)+8 > 0xFFFF {
+			return data
+		}
+		block = []byte{0xFF, 0xE1}
+		block = binary.BigEndian.AppendUint16(block, uint16(len(tiff)+8))
+		block = append(block, "Exif\x00\x00"...)
+		block = append(block, tiff...)
+		at = 2
+	}
+
+	out := make([]byte, 0, len(data)+len(block))
+	out = append(out, data[:at]...)
+	out = append(out, block...)
+	return append(out, data[at:]...)
+}
+
+// resetOrientation sets the Orientation tag of TIFF data to 1 (upright).
+func resetOrientation(b []byte) {
+	t, ok := newTIFF(b)
+	if !ok {
+		return
+	}
+	if e, ok := t.entries(t.firstIFD())[tagOrientation]; ok && e.typ == 3 && e.count == 1 {
+		t.order.PutUint16(t.b[e.pos+8:], 1)
+	}
+}
+
+// tiffEntry is one 12-byte IFD entry.
+type tiffEntry struct {
+	pos   int // offset of the entry within the TIFF data
+	tag   uint16
+	typ   uint16
+	count uint32
+}
+
+// tiff is a bounds-checked view of TIFF-structured EXIF data.
+type tiff struct {
+	b     []byte
+	order binary.ByteOrder
+}
+
+func newTIFF(b []byte) (tiff, bool) {
+	if len(b) < 8 {
+		return tiff{}, false
+	}
+	t := tiff{b: b}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return tiff{}, false
+	}
+	return t, t.order.Uint16(b[2:]) == 42
+}
+
+func (t tiff) firstIFD() uint32 {
+	return t.order.Uint32(t.b[4:])
+}
+
+// entries reads the IFD at off, keyed by tag.
+func (t tiff) entries(off uint32) map[uint16]tiffEntry {
+	m := map[uint16]tiffEntry{}
+	for _, e := range t.entryList(off) {
+		m[e.tag] = e
+	}
+	return m
+}
+
+func (t tiff) entryList(off uint32) []tiffEntry {
+	if off == 0 || int64(off)+2 > int64(len(t.b)) {
+		return nil
+	}
+	n := int(t.order.Uint16(t.b[off:]))
+	if int(off)+2+12*n > len(t.b) {
+		return nil
+	}
+
+	list := make([]tiffEntry, n)
+	for i := range list {
+		pos := int(off) + 2 + 12*i
+		list[i] = tiffEntry{
+			pos:   pos,
+			tag:   t.order.Uint16(t.b[pos:]),
+			typ:   t.order.Uint16(t.b[pos+2:]),
+			count: t.order.Uint32(t.b[pos+4:]),
+		}
+	}
+	return list
+}
+
+// tiffTypeSizes maps TIFF field types to the size of one value.
+var tiffTypeSizes = map[uint16]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// value returns the bytes of an entry's value, which are stored inline when
+// they fit in four bytes and at an offset otherwise. inline reports which.
+func (t tiff) value(e tiffEntry) (v []byte, inline bool) {
+	size := int64(tiffTypeSizes[e.typ]) * int64(e.count)
+	if size == 0 {
+		return nil, true
+	}
+	if size <= 4 {
+		return t.b[e.pos+8 : e.pos+8+int(size)], true
+	}
+	off := int64(t.order.Uint32(t.b[e.pos+8:]))
+	if off+size > int64(len(t.b)) {
+		return nil, false
+	}
+	return t.b[off : off+size], false
+}
+
+func (t tiff) ascii(e tiffEntry) string {
+	if e.typ != 2 {
+		return ""
+	}
+	v, _ := t.value(e)
+	return string(bytes.TrimRight(v, "\x00"))
+}
+
+func (t tiff) short(e tiffEntry) (uint16, bool) {
+	if e.typ != 3 || e.count != 1 {
+		return 0, false
+	}
+	return t.order.Uint16(t.b[e.pos+8:]), true
+}
+
+func (t tiff) long(e tiffEntry) (uint32, bool) {
+	if (e.typ != 4 && e.typ != 13) || e.count != 1 {
+		return 0, false
+	}
+	return t.order.Uint32(t.b[e.pos+8:]), true
+}
+
+// removeEntries deletes the entries with the given tags from the IFD at off,
+// zeroing their out-of-line values (and for sub-IFD pointers, the sub-IFD)
+// so the removed data does not linger in the file.
+func (t tiff) removeEntries(off uint32, tags map[uint16]bool) {
+	list := t.entryList(off)
+	if list == nil {
+		return
+	}
+
+	nextPos := int(off) + 2 + 12*len(list)
+	var next []byte
+	if nextPos+4 <= len(t.b) {
+		next = append(next, t.b[nextPos:nextPos+4]...)
+	}
+
+	var kept [][]byte
+	for _, e := range list {
+		if !tags[e.tag] {
+			kept = append(kept, append([]byte(nil), t.b[e.pos:e.pos+12]...))
+			continue
+		}
+		if e.tag == tagGPSIFD {
+			if ptr, ok := t.long(e); ok {
+				t.zeroIFD(ptr)
+			}
+		}
+		if v, inline := t.value(e); !inline {
+			zero(v)
+		}
+	}
+
+	if len(kept) == len(list) {
+		return
+	}
+
+	t.order.PutUint16(t.b[off:], uint16(len(kept)))
+	pos := int(off) + 2
+	for _, raw := range kept {
+		copy(t.b[pos:], raw)
+		pos += 12
+	}
+	if next != nil {
+		copy(t.b[pos:], next)
+		pos += 4
+	}
+	zero(t.b[pos : nextPos+len(next)])
+}
+
+// zeroIFD wipes an IFD together with its out-of-line values.
+func (t tiff) zeroIFD(off uint32) {
+	list := t.entryList(off)
+	if list == nil {
+		return
+	}
+	for _, e := range list {
+		if v, inline := t.value(e); !inline {
+			zero(v)
+		}
+	}
+	zero(t.b[off : int(off)+2+12*len(list)])
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
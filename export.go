@@ -0,0 +1,126 @@
+package main
+
+import (
+	"archive/zip"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+const exportManifestName = "manifest.json"
+
+type ExportManifest struct {
+	ExportedAt time.Time             `json:"exportedAt"`
+	Pictures   []ExportManifestEntry `json:"pictures"`
+}
+
+type ExportManifestEntry struct {
+	ID          string `json:"id"`
+	Title       string `json:"title,omitempty"`
+	Caption     string `json:"caption,omitempty"`
+	AltText     string `json:"altText,omitempty"`
+	File        string `json:"file"`
+	ContentType string `json:"contentType"`
+	Size        int    `json:"size"`
+}
+
+// fileExtensions maps sniffed content types to the extension used inside archives.
+var fileExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/bmp":  ".bmp",
+}
+
+// exportFileName returns the archive entry name for a picture.
+func exportFileName(id, contentType string) string {
+	ext, ok := fileExtensions[contentType]
+	if !ok {
+		ext = ".bin"
+	}
+	return id + ext
+}
+
+// exportCatPics godoc
+// @Summary Export cat pictures as a ZIP archive
+// @Description Stream a ZIP archive of all (or filtered) cat pictures together with a JSON manifest
+// @Tags catpics
+// @Produce  application/zip
+// @Param   ids    query  string  false  "Comma-separated list of at most 1000 IDs to include"
+// @Param   tag    query  []string  false  "Only pictures carrying every given tag" collectionFormat(multi)
+// @Param   album  query  string  false  "Only pictures in the given album"
+// @Success 200 {file} file "ZIP archive"
+// @Failure 400 {object} Problem "Too many IDs"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/export.zip [get]
+func ExportCatPics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		filter, ok := parseCatPicFilter(w, r)
+		if !ok {
+			return
+		}
+		where, args := filter.filterClause()
+		rows, err := traced(r.Context(), db).Query("SELECT p.id, p.title, p.caption, p.alt_text, b.data FROM cat_pics p JOIN blobs b ON b.hash = p.hash"+where+filter.orderClause(" ORDER BY p.id"), args...)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		w.Header().Set("Content-Type", "application/zip")
+		w.Header().Set("Content-Disposition", `attachment; filename="catpics.zip"`)
+
+		// Once the first byte is written the status is fixed, so failures
+		// past this point can only be logged and the archive left truncated.
+		zw := zip.NewWriter(w)
+		manifest := ExportManifest{ExportedAt: time.Now().UTC(), Pictures: []ExportManifestEntry{}}
+		for rows.Next() {
+			var entry ExportManifestEntry
+			var data []byte
+			if err := rows.Scan(&entry.ID, &entry.Title, &entry.Caption, &entry.AltText, &data); err != nil {
+				logError(r, "Error reading cat picture for export", err)
+				return
+			}
+
+			data = servedImage(data)
+			entry.ContentType = http.DetectContentType(data)
+			entry.Size = len(data)
+			entry.File = exportFileName(entry.ID, entry.ContentType)
+
+			fw, err := zw.CreateHeader(&zip.FileHeader{Name: entry.File, Method: zip.Store, Modified: manifest.ExportedAt})
+			if err != nil {
+				logError(r, "Error adding picture to export", err, "file", entry.File)
+				return
+			}
+			if _, err := fw.Write(data); err != nil {
+				logError(r, "Error writing picture to export", err, "file", entry.File)
+				return
+			}
+
+			manifest.Pictures = append(manifest.Pictures, entry)
+		}
+
+		if err := rows.Err(); err != nil {
+			logError(r, "Error iterating cat pictures for export", err)
+			return
+		}
+
+		fw, err := zw.Create(exportManifestName)
+		if err != nil {
+			logError(r, "Error adding manifest to export", err)
+			return
+		}
+		enc := json.NewEncoder(fw)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(manifest); err != nil {
+			logError(r, "Error writing manifest to export", err)
+			return
+		}
+
+		if err := zw.Close(); err != nil {
+			logError(r, "Error finishing export", err)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"image/gif"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// Animation describes the frames of a GIF.
+type Animation struct {
+	Frames int `json:"frames"`
+	// DurationMs is the time one pass over all frames takes.
+	DurationMs int `json:"durationMs"`
+	// LoopCount follows image/gif: 0 loops forever, -1 plays once and n > 0
+	// repeats n times after the first pass.
+	LoopCount int `json:"loopCount"`
+}
+
+// readGIFAnimation walks the block structure of a GIF and reports its frame
+// count, total delay and loop count. No pixel data is decompressed, so this is
+// cheap even for large or hostile files. ok is false if data is not a GIF.
+func readGIFAnimation(data []byte) (anim Animation, ok bool) {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return Animation{}, false
+	}
+	anim.LoopCount = -1
+
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << ((flags & 7) + 1) // global colour table
+	}
+
+	// skipSubBlocks returns the offset after a chain of data sub-blocks.
+	skipSubBlocks := func(i int) int {
+		for i < len(data) && data[i] != 0 {
+			i += 1 + int(data[i])
+		}
+		return i + 1
+	}
+
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // extension
+			if i+2 >= len(data) {
+				return anim, true
+			}
+			label, block := data[i+1], i+2
+			switch {
+			case label == 0xF9 && block+5 <= len(data) && data[block] == 4:
+				// Graphic control extension; the delay is in hundredths of a second.
+				anim.DurationMs += 10 * int(binary.LittleEndian.Uint16(data[block+2:]))
+			case label == 0xFF && block+16 <= len(data) && data[block] == 11 &&
+				(string(data[block+1:block+12]) == "NETSCAPE2.0" || string(data[block+1:block+12]) == "ANIMEXTS1.0") &&
+				data[block+12] == 3 && data[block+13] == 1:
+				anim.LoopCount = int(binary.LittleEndian.Uint16(data[block+14:]))
+			}
+			i = skipSubBlocks(block)
+		case 0x2C: // image descriptor
+			if i+10 > len(data) {
+				return anim, true
+			}
+			anim.Frames++
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << ((flags & 7) + 1) // local colour table
+			}
+			i = skipSubBlocks(i + 1) // LZW minimum code size, then image data
+		default: // trailer or garbage
+			return anim, true
+		}
+	}
+	return anim, true
+}
+
+// posterImage returns the first frame of data drawn on a canvas of the
+// picture's full size. For formats other than GIF that is the picture itself.
+func posterImage(data []byte) (image.Image, error) {
+	img, format, err := decodeImage(data)
+	if err != nil || format != "gif" {
+		return img, err
+	}
+
+	// GIF frames may cover only part of the logical screen.
+	cfg, err := gif.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, cfg.Width, cfg.Height))
+	draw.Draw(canvas, img.Bounds(), img, img.Bounds().Min, draw.Src)
+	return canvas, nil
+}
+
+// posterCatPic godoc
+// @Summary Get a still preview of a cat picture
+// @Description Return the first frame of an animated GIF as a static PNG. Other pictures are returned as PNG unchanged.
+// @Tags catpics
+// @Produce  png
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Success 200  {file}    binary
+// @Failure 404  {object}  Problem
+// @Failure 422  {object}  Problem            "Picture is not a decodable image or exceeds the dimension limits"
+// @Failure 500  {object}  Problem
+// @Router /v1/catpics/{id}/poster [get]
+func PosterCatPic(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		hash, data, err := loadCatPicBlob(traced(r.Context(), db), id)
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		case err != nil:
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		key := "poster|" + hash
+		out, ok := convertedImages.get(key)
+		if !ok {
+			_, span := startSpan(r.Context(), "posterImage")
+			img, err := posterImage(data)
+			endSpan(span, err)
+			switch {
+			case errors.Is(err, errImageTooLarge):
+				writeProblem(w, r, problemImageTooLarge, imageTooLargeMessage(err))
+				return
+			case err != nil:
+				writeProblem(w, r, problemUndecodableImage, "Cat picture is not a decodable image")
+				return
+			}
+			_, span = startSpan(r.Context(), "encodeImage")
+			out, err = encodeImage(img, "image/png", 0)
+			endSpan(span, err)
+			if err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			convertedImages.put(key, out)
+		}
+
+		w.Header().Set("Content-Type", "image/png")
+		if _, err := w.Write(out); err != nil {
+			logError(r, "Error writing image to response", err)
+		}
+	}
+}
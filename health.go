@@ -0,0 +1,151 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+)
+
+// readinessTimeout bounds how long the readiness checks may take together.
+const readinessTimeout = 2 * time.Second
+
+// Health check statuses.
+const (
+	healthOK      = "ok"
+	healthFailing = "failing"
+	healthSkipped = "skipped"
+)
+
+// HealthCheck is the outcome of one readiness check.
+type HealthCheck struct {
+	Status string `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// HealthStatus is the overall health of the service, with the individual
+// checks that decided it.
+type HealthStatus struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks,omitempty"`
+}
+
+// healthz godoc
+// @Summary Liveness check
+// @Description Report that the process is up and serving requests. Does not look at the database.
+// @Tags health
+// @Produce  json
+// @Success 200 {object} HealthStatus
+// @Router /healthz [get]
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, HealthStatus{Status: healthOK}, http.StatusOK)
+}
+
+// readyz godoc
+// @Summary Readiness check
+// @Description Report whether the service can take traffic: the database answers, all migrations are applied, picture storage is writable and the disk has enough free space. Fails while the server is shutting down.
+// @Tags health
+// @Produce  json
+// @Success 200 {object} HealthStatus
+// @Failure 503 {object} HealthStatus "Not ready"
+// @Router /readyz [get]
+func Readyz(db *sql.DB, dataDir string, shuttingDown *atomic.Bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+		defer cancel()
+
+		checks := map[string]HealthCheck{
+			"database":   checkDatabase(ctx, db),
+			"migrations": checkMigrations(ctx, db),
+			"storage":    checkStorage(ctx, db),
+			"disk":       checkDisk(dataDir),
+		}
+		if shuttingDown.Load() {
+			checks["shutdown"] = HealthCheck{Status: healthFailing, Detail: "server is shutting down"}
+		}
+
+		status, code := healthOK, http.StatusOK
+		for _, check := range checks {
+			if check.Status == healthFailing {
+				status, code = "unavailable", http.StatusServiceUnavailable
+			}
+		}
+		w.Header().Set("Cache-Control", "no-store")
+		jsonResponse(w, HealthStatus{Status: status, Checks: checks}, code)
+	}
+}
+
+func failing(err error) HealthCheck {
+	return HealthCheck{Status: healthFailing, Detail: err.Error()}
+}
+
+func checkDatabase(ctx context.Context, db *sql.DB) HealthCheck {
+	if err := db.PingContext(ctx); err != nil {
+		return failing(err)
+	}
+	return HealthCheck{Status: healthOK}
+}
+
+func checkMigrations(ctx context.Context, db *sql.DB) HealthCheck {
+	var version int
+	if err := db.QueryRowContext(ctx, "PRAGMA user_version").Scan(&version); err != nil {
+		return failing(err)
+	}
+	detail := fmt.Sprintf("%d of %d applied", version, len(migrations))
+	if version < len(migrations) {
+		return HealthCheck{Status: healthFailing, Detail: detail}
+	}
+	return HealthCheck{Status: healthOK, Detail: detail}
+}
+
+// checkStorage reads from the blob table and checks that the database file
+// and its directory can be written. It never takes the database write lock,
+// so a long upload or import does not make the service look unready.
+func checkStorage(ctx context.Context, db *sql.DB) HealthCheck {
+	var file string
+	if err := db.QueryRowContext(ctx, "SELECT file FROM pragma_database_list WHERE name = 'main'").Scan(&file); err != nil {
+		return failing(err)
+	}
+	var hasBlobs bool
+	if err := db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM blobs)").Scan(&hasBlobs); err != nil {
+		return failing(err)
+	}
+	if file == "" {
+		return HealthCheck{Status: healthOK, Detail: "in-memory database"}
+	}
+
+	f, err := os.OpenFile(file, os.O_WRONLY, 0)
+	if err != nil {
+		return failing(err)
+	}
+	f.Close()
+	// SQLite creates its journal next to the database file.
+	probe, err := os.CreateTemp(filepath.Dir(file), ".readyz-*")
+	if err != nil {
+		return failing(err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return HealthCheck{Status: healthOK}
+}
+
+func checkDisk(dir string) HealthCheck {
+	free, ok, err := diskFree(dir)
+	switch {
+	case err != nil:
+		return failing(err)
+	case !ok:
+		return HealthCheck{Status: healthSkipped, Detail: "free space is unknown on this platform"}
+	}
+
+	min := uint64(config.MinFreeDiskMB) << 20
+	detail := fmt.Sprintf("%d MB free, %d MB required", free>>20, config.MinFreeDiskMB)
+	if free < min {
+		return HealthCheck{Status: healthFailing, Detail: detail}
+	}
+	return HealthCheck{Status: healthOK, Detail: detail}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// maxIdempotencyKeyLength bounds Idempotency-Key headers; UUIDs, the usual
+// choice, are 36 characters.
+const maxIdempotencyKeyLength = 255
+
+// errIdempotencyKeyInUse is returned by saveIdempotencyKey when a concurrent
+// request stored a response under the same key first.
+var errIdempotencyKeyInUse = errors.New("idempotency key in use")
+
+// idempotentResponse is the response stored for an Idempotency-Key, along
+// with the fingerprint of the request that produced it.
+type idempotentResponse struct {
+	Fingerprint string
+	Status      int
+	Header      http.Header
+	Body        []byte
+}
+
+// uploadFingerprint identifies an upload by its bytes and tags, so a retry
+// matches however the client orders, splits, repeats or capitalizes the tags.
+// Tags that normalizeTag rejects are kept as given; such uploads fail anyway.
+func uploadFingerprint(data []byte, tags []string) string {
+	var names []string
+	seen := map[string]bool{}
+	for _, v := range tags {
+		for _, name := range strings.Split(v, ",") {
+			name = strings.TrimSpace(name)
+			if tag, err := normalizeTag(name); err == nil {
+				name = tag
+			}
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	h := sha256.New()
+	h.Write([]byte(contentHash(data)))
+	for _, name := range names {
+		h.Write([]byte{0})
+		h.Write([]byte(name))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// newIdempotentResponse records the response jsonResponse would write for v
+// with header set.
+func newIdempotentResponse(fingerprint string, status int, header http.Header, v interface{}) (idempotentResponse, error) {
+	var body bytes.Buffer
+	err := json.NewEncoder(&body).Encode(v)
+	return idempotentResponse{Fingerprint: fingerprint, Status: status, Header: header, Body: body.Bytes()}, err
+}
+
+// lookupIdempotencyKey returns the response stored for key, unless it has
+// expired.
+func lookupIdempotencyKey(q dbtx, key string, now time.Time) (idempotentResponse, bool, error) {
+	var resp idempotentResponse
+	var header string
+	err := q.QueryRow("SELECT fingerprint, status, headers, body FROM idempotency_keys WHERE key = ? AND created_at > ?",
+		key, now.Add(-config.IdempotencyTTL).UTC().Format(time.RFC3339)).Scan(&resp.Fingerprint, &resp.Status, &header, &resp.Body)
+	if err == sql.ErrNoRows {
+		return resp, false, nil
+	}
+	if err != nil {
+		return resp, false, err
+	}
+	return resp, true, json.Unmarshal([]byte(header), &resp.Header)
+}
+
+// saveIdempotencyKey stores resp under key, replacing an expired response,
+// and drops the other expired ones while it is at it.
+func saveIdempotencyKey(tx dbtx, key string, resp idempotentResponse, now time.Time) error {
+	cutoff := now.Add(-config.IdempotencyTTL).UTC().Format(time.RFC3339)
+	if _, err := tx.Exec("DELETE FROM idempotency_keys WHERE created_at <= ?", cutoff); err != nil {
+		return err
+	}
+
+	header, err := json.Marshal(resp.Header)
+	if err != nil {
+		return err
+	}
+	res, err := tx.Exec("INSERT INTO idempotency_keys (key, fingerprint, status, headers, body, created_at) VALUES (?, ?, ?, ?, ?, ?) ON CONFLICT (key) DO NOTHING",
+		key, resp.Fingerprint, resp.Status, string(header), resp.Body, now.UTC().Format(time.RFC3339))
+	if err != nil {
+		return err
+	}
+	n, err := res.RowsAffected()
+	if err == nil && n == 0 {
+		err = errIdempotencyKeyInUse
+	}
+	return err
+}
+
+// replayIdempotencyKey answers a request from the response stored for key,
+// if there is one, and reports whether it did. A key reused for a different
+// request is rejected.
+func replayIdempotencyKey(w http.ResponseWriter, r *http.Request, db *sql.DB, key, fingerprint string) bool {
+	resp, found, err := lookupIdempotencyKey(db, key, time.Now())
+	switch {
+	case err != nil:
+		serverError(w, r, "Error executing database operation", err)
+		return true
+	case !found:
+		return false
+	case resp.Fingerprint != fingerprint:
+		writeProblem(w, r, problemIdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+		return true
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+	return true
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/google/uuid"
+)
+
+// catPicIDPattern is what client-chosen picture IDs must look like. Generated
+// UUIDs match it; dots are left out so an ID plus its extension is an
+// unambiguous export file name.
+var catPicIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9_-]{0,63}$`)
+
+// reservedCatPicIDs are path segments under /catpics that routes other than
+// /catpics/{id} claim, so pictures with these IDs could not be fetched.
+var reservedCatPicIDs = map[string]bool{"search": true, "import": true}
+
+// validCatPicID reports whether id may be used for a new cat picture.
+func validCatPicID(id string) bool {
+	return catPicIDPattern.MatchString(id) && !reservedCatPicIDs[id]
+}
+
+// newID generates an ID for a new picture or album: a random UUID, or a
+// time-ordered UUIDv7 if configured, which keeps inserts at the end of the
+// primary key index.
+func newID() string {
+	if config.TimeOrderedIDs {
+		if id, err := uuid.NewV7(); err == nil {
+			return id.String()
+		}
+	}
+	return uuid.NewString()
+}
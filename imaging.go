@@ -0,0 +1,184 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"math/bits"
+)
+
+// reencodeJPEGQuality is used whenever a stored JPEG has to be re-encoded.
+const reencodeJPEGQuality = 90
+
+// errImageTooLarge is returned for pictures whose dimensions exceed the
+// configured limits.
+var errImageTooLarge = errors.New("image dimensions too large")
+
+// checkImageSize rejects dimensions beyond the configured limits. A few
+// kilobytes of PNG can describe a bitmap of many gigabytes, so this must be
+// checked with image.DecodeConfig before anything is decoded.
+func checkImageSize(width, height int) error {
+	if width > config.MaxImageWidth || height > config.MaxImageHeight || width*height > config.MaxImagePixels {
+		return fmt.Errorf("%w: %dx%d exceeds the limit of %dx%d and %d pixels",
+			errImageTooLarge, width, height, config.MaxImageWidth, config.MaxImageHeight, config.MaxImagePixels)
+	}
+	return nil
+}
+
+// decodeImage decodes any of the image formats registered with the image
+// package, refusing pictures that exceed the dimension limits.
+func decodeImage(data []byte) (image.Image, string, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkImageSize(cfg.Width, cfg.Height); err != nil {
+		return nil, "", err
+	}
+	return image.Decode(bytes.NewReader(data))
+}
+
+// perceptualHash computes a 64-bit difference hash (dHash) of data. The image is
+// shrunk to 9x8 grey cells and each bit records whether a cell is brighter than
+// its right-hand neighbour, which survives resizing and recompression. ok is
+// false if data is not a decodable image.
+func perceptualHash(data []byte) (hash uint64, ok bool) {
+	img, _, err := decodeImage(data)
+	if err != nil {
+		return 0, false
+	}
+	return dHash(img), true
+}
+
+func dHash(img image.Image) uint64 {
+	const w, h = 9, 8
+	var cells [h][w]float64
+
+	b := img.Bounds()
+	cellW := float64(b.Dx()) / w
+	cellH := float64(b.Dy()) / h
+	if cellW == 0 || cellH == 0 {
+		return 0
+	}
+
+	// Averaging a fixed grid of samples per cell keeps the cost independent
+	// of the image size.
+	const samples = 4
+	for cy := 0; cy < h; cy++ {
+		for cx := 0; cx < w; cx++ {
+			var sum float64
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					x := b.Min.X + int((float64(cx)+(float64(sx)+0.5)/samples)*cellW)
+					y := b.Min.Y + int((float64(cy)+(float64(sy)+0.5)/samples)*cellH)
+					sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+				}
+			}
+			cells[cy][cx] = sum / (samples * samples)
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// hammingDistance counts the bits that differ between two perceptual hashes.
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
+
+// autoOrient rotates and flips a JPEG or PNG picture so that it is upright
+// without the EXIF Orientation tag, then stores the tag as 1. The rest of the
+// EXIF block is carried over. Pictures that are already upright, or that
+// cannot be decoded, are returned unchanged.
+func autoOrient(data []byte) []byte {
+	block, ok := findExif(data)
+	if !ok {
+		return data
+	}
+	orientation := readExif(data).Orientation
+	if orientation <= 1 {
+		return data
+	}
+	img, format, err := decodeImage(data)
+	if err != nil {
+		return data
+	}
+
+	var buf bytes.Buffer
+	switch format {
+	case "jpeg":
+		err = jpeg.Encode(&buf, orientImage(img, orientation), &jpeg.Options{Quality: reencodeJPEGQuality})
+	case "png":
+		err = png.Encode(&buf, orientImage(img, orientation))
+	default:
+		return data
+	}
+	if err != nil {
+		return data
+	}
+
+	tiff := append([]byte(nil), block.tiff...)
+	resetOrientation(tiff)
+	return withExif(buf.Bytes(), tiff, block.png)
+}
+
+// orientImage applies the transformation described by an EXIF Orientation
+// value (1-8) so that the result is displayed upright.
+func orientImage(img image.Image, orientation int) image.Image {
+	src := toNRGBA(img)
+	w, h := src.Rect.Dx(), src.Rect.Dy()
+	dw, dh := w, h
+	if orientation >= 5 {
+		dw, dh = h, w
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, dw, dh))
+
+	for sy := 0; sy < h; sy++ {
+		for sx := 0; sx < w; sx++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // flip horizontally
+				dx, dy = w-1-sx, sy
+			case 3: // rotate 180°
+				dx, dy = w-1-sx, h-1-sy
+			case 4: // flip vertically
+				dx, dy = sx, h-1-sy
+			case 5: // transpose
+				dx, dy = sy, sx
+			case 6: // rotate 90° clockwise
+				dx, dy = h-1-sy, sx
+			case 7: // transverse
+				dx, dy = h-1-sy, w-1-sx
+			case 8: // rotate 90° counter-clockwise
+				dx, dy = sy, w-1-sx
+			default:
+				dx, dy = sx, sy
+			}
+			copy(dst.Pix[dst.PixOffset(dx, dy):][:4], src.Pix[src.PixOffset(sx, sy):][:4])
+		}
+	}
+	return dst
+}
+
+// toNRGBA copies img into an NRGBA image whose bounds start at the origin.
+func toNRGBA(img image.Image) *image.NRGBA {
+	b := img.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), img, b.Min, draw.Src)
+	return dst
+}
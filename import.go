@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+)
+
+const (
+	maxImportSize   = 1 << 30 // 1 GB
+	importBatchSize = 100
+)
+
+var errUnsupportedArchive = errors.New("unsupported archive format, expected ZIP or tar.gz")
+
+// errEmptyFile rejects empty archive entries, which are usually directories
+// or placeholders rather than pictures. Uploads through the API may be empty.
+var errEmptyFile = errors.New("empty file")
+
+type ImportReport struct {
+	Imported []ImportedCatPic `json:"imported"`
+	Failed   []ImportFailure  `json:"failed"`
+}
+
+type ImportedCatPic struct {
+	File string `json:"file"`
+	ID   string `json:"id"`
+}
+
+type ImportFailure struct {
+	File  string `json:"file"`
+	Error string `json:"error"`
+}
+
+// importCatPics godoc
+// @Summary Import cat pictures from an archive
+// @Description Import every picture in a ZIP or tar.gz archive, such as one produced by the export endpoint. Entries named after a valid picture ID keep that ID.
+// @Tags catpics
+// @Accept  mpfd
+// @Produce  json
+// @Param   archive  formData  file  true  "ZIP or tar.gz archive"
+// @Success 200  {object}  ImportReport
+// @Failure 400  {object}  Problem
+// @Failure 413  {object}  Problem
+// @Failure 500  {object}  Problem
+// @Router /v1/catpics/import [post]
+func ImportCatPics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxImportSize {
+			writeProblem(w, r, problemUploadTooLarge, "Archive too large")
+			return
+		}
+
+		r.Body = http.MaxBytesReader(w, r.Body, maxImportSize)
+
+		if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+			writeProblem(w, r, problemUploadTooLarge, "Archive too large or invalid")
+			return
+		}
+		defer r.MultipartForm.RemoveAll()
+
+		file, header, err := r.FormFile("archive")
+		if err != nil {
+			writeProblem(w, r, problemInvalidArchive, "Invalid archive")
+			return
+		}
+		defer file.Close()
+
+		report, err := importArchive(db, file, header.Size)
+		switch {
+		case errors.Is(err, errUnsupportedArchive):
+			writeProblem(w, r, problemInvalidArchive, "Unsupported archive format")
+		case err != nil:
+			serverError(w, r, "Error importing archive", err)
+		default:
+			jsonResponse(w, report, http.StatusOK)
+		}
+	}
+}
+
+// importCommand implements the "import <archive>..." subcommand.
+func importCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return errors.New("usage: catpics-api import <archive>...")
+	}
+
+	for _, name := range args {
+		f, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return err
+		}
+
+		report, err := importArchive(db, f, info.Size())
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("importing %s: %w", name, err)
+		}
+
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(report); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// importEntry is a single picture read from an archive.
+type importEntry struct {
+	file string
+	data []byte
+}
+
+// importArchive validates and inserts every picture in a ZIP or tar.gz archive,
+// committing them in batches of importBatchSize.
+func importArchive(db *sql.DB, r io.ReaderAt, size int64) (ImportReport, error) {
+	report := ImportReport{Imported: []ImportedCatPic{}, Failed: []ImportFailure{}}
+
+	var batch []importEntry
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := insertImportBatch(db, batch, &report)
+		batch = batch[:0]
+		return err
+	}
+
+	err := walkArchive(r, size, func(name string, entry io.Reader) error {
+		data, err := io.ReadAll(io.LimitReader(entry, maxUploadSize+1))
+		if err != nil {
+			return err
+		}
+		observeUpload("import", len(data))
+
+		if len(data) == 0 {
+			err = errEmptyFile
+		} else {
+			err = validateCatPic(data)
+		}
+		if err != nil {
+			report.Failed = append(report.Failed, ImportFailure{File: name, Error: err.Error()})
+			return nil
+		}
+
+		batch = append(batch, importEntry{file: name, data: prepareCatPic(data)})
+		if len(batch) >= importBatchSize {
+			return flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return report, err
+	}
+
+	return report, flush()
+}
+
+// insertImportBatch inserts a batch of entries in one transaction. Entries that
+// cannot be inserted, for example because their ID already exists, are reported
+// as failures without aborting the rest of the batch. Each entry runs in a
+// savepoint, so a failed one leaves nothing behind, such as a blob reference.
+func insertImportBatch(db *sql.DB, batch []importEntry, report *ImportReport) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var imported []ImportedCatPic
+	for _, e := range batch {
+		id := importID(e.file)
+		if _, err := tx.Exec("SAVEPOINT import_entry"); err != nil {
+			return err
+		}
+		if err := insertCatPic(tx, id, e.data); err != nil {
+			if _, err := tx.Exec("ROLLBACK TO import_entry"); err != nil {
+				return err
+			}
+			report.Failed = append(report.Failed, ImportFailure{File: e.file, Error: err.Error()})
+		} else {
+			imported = append(imported, ImportedCatPic{File: e.file, ID: id})
+		}
+		if _, err := tx.Exec("RELEASE import_entry"); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	report.Imported = append(report.Imported, imported...)
+	return nil
+}
+
+// importID reuses the ID encoded in an exported file name, which may be a
+// client-chosen one, falling back to a freshly generated one.
+func importID(file string) string {
+	base := path.Base(file)
+	if id := strings.TrimSuffix(base, path.Ext(base)); validCatPicID(id) {
+		return id
+	}
+	return newID()
+}
+
+// walkArchive calls fn for every regular file in a ZIP or tar.gz archive,
+// skipping the export manifest.
+func walkArchive(r io.ReaderAt, size int64, fn func(name string, entry io.Reader) error) error {
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, 0); err != nil {
+		return errUnsupportedArchive
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte("PK\x03\x04")):
+		return walkZip(r, size, fn)
+	case bytes.Equal(magic[:2], []byte{0x1f, 0x8b}):
+		return walkTarGz(io.NewSectionReader(r, 0, size), fn)
+	default:
+		return errUnsupportedArchive
+	}
+}
+
+func walkZip(r io.ReaderAt, size int64, fn func(name string, entry io.Reader) error) error {
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsupportedArchive, err)
+	}
+
+	for _, f := range zr.File {
+		if f.FileInfo().IsDir() || path.Base(f.Name) == exportManifestName {
+			continue
+		}
+
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		err = fn(f.Name, rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func walkTarGz(r io.Reader, fn func(name string, entry io.Reader) error) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("%w: %v", errUnsupportedArchive, err)
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if hdr.Typeflag != tar.TypeReg || path.Base(hdr.Name) == exportManifestName {
+			continue
+		}
+
+		if err := fn(hdr.Name, tr); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/gorilla/mux"
+)
+
+// maxRequestIDLength bounds X-Request-ID headers taken over from clients.
+const maxRequestIDLength = 128
+
+// requestInfo is filled in while a request is served and read back by
+// accessLog. A pointer to it lives in the request context.
+type requestInfo struct {
+	ID    string
+	Route string
+}
+
+type requestInfoKey struct{}
+
+// requestInfoFrom returns the requestInfo of the request ctx belongs to, or
+// nil outside accessLog.
+func requestInfoFrom(ctx context.Context) *requestInfo {
+	info, _ := ctx.Value(requestInfoKey{}).(*requestInfo)
+	return info
+}
+
+// requestID returns the ID accessLog assigned to r, if any.
+func requestID(r *http.Request) string {
+	if info := requestInfoFrom(r.Context()); info != nil {
+		return info.ID
+	}
+	return ""
+}
+
+// newLogger returns the JSON logger the server logs through.
+func newLogger() *slog.Logger {
+	return slog.New(slog.NewJSONHandler(os.Stdout, nil))
+}
+
+// validRequestID reports whether a client-supplied request ID is short and
+// printable enough to be echoed and logged.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x21 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// statusRecorder captures the status code and body size written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int
+}
+
+func (w *statusRecorder) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += n
+	return n, err
+}
+
+// accessLog assigns every request an ID, taking over a valid X-Request-ID
+// from the client and echoing it in the response, and logs and counts every
+// request once it has been served.
+func accessLog(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		info := &requestInfo{ID: r.Header.Get("X-Request-ID")}
+		if !validRequestID(info.ID) {
+			info.ID = uuid.NewString()
+		}
+		w.Header().Set("X-Request-ID", info.ID)
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestInfoKey{}, info)))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		elapsed := time.Since(start)
+		observeRequest(r.Method, info.Route, rec.status, elapsed)
+
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+		logger.LogAttrs(r.Context(), slog.LevelInfo, "request",
+			slog.String("request_id", info.ID),
+			slog.String("method", r.Method),
+			slog.String("route", info.Route),
+			slog.String("path", r.URL.Path),
+			slog.Int("status", rec.status),
+			slog.Int("bytes", rec.bytes),
+			slog.Float64("latency_ms", float64(elapsed.Microseconds())/1000),
+			slog.String("client", client),
+		)
+	})
+}
+
+// recordRoute is router middleware noting the matched route template for
+// accessLog, which runs before routing and cannot see it otherwise.
+func recordRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if info := requestInfoFrom(r.Context()); info != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				info.Route, _ = route.GetPathTemplate()
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// logError logs an error that occurred while serving r, tagged with its
+// request ID and any further key-value pairs in args.
+func logError(r *http.Request, msg string, err error, args ...any) {
+	slog.ErrorContext(r.Context(), msg, append([]any{"request_id", requestID(r), "error", err}, args...)...)
+}
+
+// serverError logs err and answers r with a 500 carrying message, which
+// does not reveal err to the client.
+func serverError(w http.ResponseWriter, r *http.Request, message string, err error) {
+	logError(r, message, err)
+	writeProblem(w, r, problemInternal, message)
+}
@@ -1,14 +1,27 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
 	"io"
 	"io/ioutil"
 	"log"
+	"log/slog"
 	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
-	"github.com/google/uuid"
 	"github.com/gorilla/mux"
 	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/sid0jack/catpics-api/docs"
@@ -23,41 +36,204 @@ type CatPic struct {
 }
 
 type CatPicResponse struct {
-	ID string `json:"id"`
+	ID          string `json:"id"`
+	Title       string `json:"title"`
+	Caption     string `json:"caption"`
+	AltText     string `json:"altText"`
+	Camera      string `json:"camera,omitempty"`
+	TakenAt     string `json:"takenAt,omitempty"`
+	Orientation int    `json:"orientation,omitempty"`
+	// Animation is only set for GIFs.
+	Animation *Animation `json:"animation,omitempty"`
+	// Palette lists the dominant colours as #rrggbb, most common first.
+	Palette  []string `json:"palette,omitempty"`
+	BlurHash string   `json:"blurHash,omitempty"`
+	// Version counts the picture's uploads; its ETag is the quoted version.
+	Version int `json:"version"`
+}
+
+// catPicResponseColumns selects the fields of a CatPicResponse from cat_pics
+// aliased as p, in the order scanCatPicResponse expects.
+const catPicResponseColumns = "p.id, p.title, p.caption, p.alt_text, p.camera, p.taken_at, p.orientation, p.frame_count, p.duration_ms, p.loop_count, p.palette, p.blur_hash, p.version"
+
+// scanCatPicResponse scans a row selected with catPicResponseColumns, followed
+// by any extra columns into extra.
+func scanCatPicResponse(row interface{ Scan(...interface{}) error }, extra ...interface{}) (CatPicResponse, error) {
+	var pic CatPicResponse
+	var anim Animation
+	var palette string
+	dest := []interface{}{&pic.ID, &pic.Title, &pic.Caption, &pic.AltText, &pic.Camera, &pic.TakenAt, &pic.Orientation,
+		&anim.Frames, &anim.DurationMs, &anim.LoopCount, &palette, &pic.BlurHash, &pic.Version}
+	err := row.Scan(append(dest, extra...)...)
+	if anim.Frames > 0 {
+		pic.Animation = &anim
+	}
+	if palette != "" {
+		pic.Palette = strings.Split(palette, ",")
+	}
+	return pic, err
 }
 
 // @title Cat Pics API
 // @version 1.0
 // @description This is a simple set of API's to store and retrieve cat pictures.
+// @description Errors are reported as RFC 7807 problem details (application/problem+json) with a stable code, described at /problems/{code}.
+// @description The unversioned paths of earlier releases (e.g. /catpics instead of /v1/catpics) still work but are deprecated and announce their removal in a Sunset header. They return bare objects rather than the /v1 envelope, and GET /catpics/{id} serves the image itself.
 // @host localhost:8080
 // @BasePath /
 func main() {
-		db, err := sql.Open("sqlite3", "./catpics.sqlite3")
-    if err != nil {
-        log.Fatalf("Error opening database: %v", err)
-    }
-    defer db.Close()
+	logger := newLogger()
+	slog.SetDefault(logger)
 
-    statement, err := db.Prepare("CREATE TABLE IF NOT EXISTS cat_pics (id TEXT PRIMARY KEY, data BLOB NOT NULL);")
-    if err != nil {
-        log.Fatalf("Error preparing statement: %v", err)
-    }
-    defer statement.Close()
+	cfg, err := loadConfig()
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	config = cfg
 
-    _, err = statement.Exec()
-    if err != nil {
-        log.Fatalf("Error executing statement: %v", err)
-    }
+	shutdownTracing, err := setupTracing(context.Background())
+	if err != nil {
+		log.Fatalf("Error setting up tracing: %v", err)
+	}
+	defer shutdownTracing(context.Background())
+
+	const dbPath = "./catpics.sqlite3"
+	db, err := openDB(dbPath)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer db.Close()
+
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1], os.Args[2:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	var shuttingDown atomic.Bool
 
 		router := mux.NewRouter()
+		router.NotFoundHandler = http.HandlerFunc(routeNotFound)
+		router.MethodNotAllowedHandler = http.HandlerFunc(methodNotAllowed)
+		router.Use(recordRoute, traceRoute)
 		router.PathPrefix("/swagger/").Handler(httpSwagger.WrapHandler)
-		router.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
-		router.HandleFunc("/catpics/{id}", GetCatPicByID(db)).Methods("GET")
-		router.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
-		router.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
-		router.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+		router.Handle("/metrics", metricsHandler(db)).Methods("GET")
+		router.HandleFunc("/healthz", Healthz).Methods("GET")
+		router.HandleFunc("/problems/{code}", GetProblemType).Methods("GET")
+		router.HandleFunc("/readyz", Readyz(db, filepath.Dir(dbPath), &shuttingDown)).Methods("GET")
+
+		v1 := router.PathPrefix(apiPrefix).Subrouter()
+		v1.Use(versionedAPI)
+		v1.HandleFunc("/catpics/{id}/data", GetCatPicByID(db)).Methods("GET")
+		apiRoutes(v1, db, GetCatPic(db))
+
+		// The unversioned routes predate /v1 and are kept for existing clients.
+		legacy := router.NewRoute().Subrouter()
+		legacy.Use(deprecatedAPI)
+		apiRoutes(legacy, db, GetCatPicByID(db))
+
+	stopPurger := startTrashPurger(db, config.TrashRetention)
+	defer stopPurger()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	server := &http.Server{Addr: ":8080", Handler: accessLog(logger, router)}
+	if err := serve(ctx, server, &shuttingDown); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// shutdownTimeout bounds how long in-flight requests may take to finish once
+// the server stops accepting new ones.
+const shutdownTimeout = 30 * time.Second
+
+// serve runs server until ctx is done. It then reports the service as not
+// ready for config.ShutdownDelay, so that load balancers stop sending traffic,
+// before shutting the server down and waiting for in-flight requests.
+func serve(ctx context.Context, server *http.Server, shuttingDown *atomic.Bool) error {
+	done := make(chan error, 1)
+	go func() {
+		<-ctx.Done()
+		shuttingDown.Store(true)
+		slog.Info("Shutting down", "delay", config.ShutdownDelay.String())
+		time.Sleep(config.ShutdownDelay)
+
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		done <- server.Shutdown(shutdownCtx)
+	}()
+
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return <-done
+}
 
-	log.Fatal(http.ListenAndServe(":8080", router))
+// apiRoutes registers the API's resources on r, serving GET /catpics/{id}
+// with getCatPic, which differs between versions.
+func apiRoutes(r *mux.Router, db *sql.DB, getCatPic http.HandlerFunc) {
+	r.HandleFunc("/catpics", CreateCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics/export.zip", ExportCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/import", ImportCatPics(db)).Methods("POST")
+	r.HandleFunc("/catpics/search", SearchCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", getCatPic).Methods("GET")
+	r.HandleFunc("/catpics/{id}/similar", SimilarCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/transform", TransformCatPic(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/poster", PosterCatPic(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/restore", RestoreCatPic(db)).Methods("POST")
+	r.HandleFunc("/catpics/{id}/versions", ListCatPicVersions(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}", GetCatPicVersion(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/versions/{n}/restore", RestoreCatPicVersion(db)).Methods("POST")
+	r.HandleFunc("/catpics/{id}", DeleteCatPic(db)).Methods("DELETE")
+	r.HandleFunc("/catpics", ListCatPics(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}", UpdateCatPic(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}", PatchCatPic(db)).Methods("PATCH")
+	r.HandleFunc("/catpics:batchDelete", BatchDeleteCatPics(db)).Methods("POST")
+	r.HandleFunc("/catpics/{id}/tags", ListCatPicTags(db)).Methods("GET")
+	r.HandleFunc("/catpics/{id}/tags/{tag}", AddCatPicTag(db)).Methods("PUT")
+	r.HandleFunc("/catpics/{id}/tags/{tag}", RemoveCatPicTag(db)).Methods("DELETE")
+	r.HandleFunc("/tags", ListTags(db)).Methods("GET")
+	r.HandleFunc("/tags", CreateTag(db)).Methods("POST")
+	r.HandleFunc("/tags/{name}", RenameTag(db)).Methods("PUT")
+	r.HandleFunc("/tags/{name}", DeleteTag(db)).Methods("DELETE")
+	r.HandleFunc("/albums", ListAlbums(db)).Methods("GET")
+	r.HandleFunc("/albums", CreateAlbum(db)).Methods("POST")
+	r.HandleFunc("/albums/{id}", GetAlbum(db)).Methods("GET")
+	r.HandleFunc("/albums/{id}", UpdateAlbum(db)).Methods("PUT")
+	r.HandleFunc("/albums/{id}", DeleteAlbum(db)).Methods("DELETE")
+	r.HandleFunc("/albums/{id}/pictures", AddAlbumPicture(db)).Methods("POST")
+	r.HandleFunc("/albums/{id}/pictures/{pictureId}", RemoveAlbumPicture(db)).Methods("DELETE")
+	r.HandleFunc("/trash", ListTrash(db)).Methods("GET")
+}
+
+// openDB opens the SQLite database at path and migrates it to the current schema.
+func openDB(path string) (*sql.DB, error) {
+	// Transactions take the write lock when they begin, so that one that reads
+	// before writing, like a deduplicating upload, waits for a concurrent one
+	// to commit instead of failing with "database is locked" or acting on
+	// stale reads.
+	db, err := sql.Open("sqlite3", "file:"+path+"?_txlock=immediate")
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// runCommand executes a command-line subcommand instead of starting the server.
+func runCommand(db *sql.DB, name string, args []string) error {
+	switch name {
+	case "import":
+		return importCommand(db, args)
+	default:
+		return fmt.Errorf("unknown command %q", name)
+	}
 }
 
 
@@ -67,67 +243,218 @@ func main() {
 // @Tags catpics
 // @Accept  json
 // @Produce  json
-// @Success 200 {array} CatPicResponse
-// @Failure 500 {object} map[string]string "Internal Server Error"
-// @Router /catpics [get]
+// @Param   ids    query  string  false  "Comma-separated list of at most 1000 IDs to include"
+// @Param   tag    query  []string  false  "Only pictures carrying every given tag" collectionFormat(multi)
+// @Param   album  query  string  false  "Only pictures in the given album"
+// @Success 200 {object} CatPicListEnvelope
+// @Failure 400 {object} Problem "Too many IDs"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics [get]
 func ListCatPics(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		rows, err := db.Query("SELECT id FROM cat_pics")
+		filter, ok := parseCatPicFilter(w, r)
+		if !ok {
+			return
+		}
+		where, args := filter.filterClause()
+		rows, err := traced(r.Context(), db).Query("SELECT "+catPicResponseColumns+" FROM cat_pics p"+where+filter.orderClause(""), args...)
 		if err != nil {
-			jsonError(w, "Server error", http.StatusInternalServerError)
+			serverError(w, r, "Server error", err)
 			return
 		}
 		defer rows.Close()
 
 		var pics []CatPicResponse
 		for rows.Next() {
-			var pic CatPicResponse
-			if err := rows.Scan(&pic.ID); err != nil {
-				jsonError(w, "Server error", http.StatusInternalServerError)
+			pic, err := scanCatPicResponse(rows)
+			if err != nil {
+				serverError(w, r, "Server error", err)
 				return
 			}
 			pics = append(pics, pic)
 		}
 
 		if err = rows.Err(); err != nil {
-			jsonError(w, "Server error", http.StatusInternalServerError)
+			serverError(w, r, "Server error", err)
 			return
 		}
 
+		if isV1(r) {
+			list := CatPicListEnvelope{Data: []CatPicResource{}, Links: ListLinks{Self: r.URL.RequestURI()}}
+			for _, pic := range pics {
+				list.Data = append(list.Data, catPicResource(pic))
+			}
+			jsonResponse(w, list, http.StatusOK)
+			return
+		}
 		jsonResponse(w, pics, http.StatusOK)
 	}
 }
 
+// maxFilterIDs bounds the ids filter, whose IDs each take a bound variable,
+// well below SQLite's limit on those.
+const maxFilterIDs = 1000
+
+// catPicFilter narrows down which cat pictures a listing query returns.
+type catPicFilter struct {
+	IDs   []string
+	Tags  []string
+	Album string
+}
+
+// parseCatPicFilter builds a catPicFilter from the request's query string,
+// writing a 400 if it lists too many IDs.
+func parseCatPicFilter(w http.ResponseWriter, r *http.Request) (catPicFilter, bool) {
+	var f catPicFilter
+	if ids := r.URL.Query().Get("ids"); ids != "" {
+		for _, id := range strings.Split(ids, ",") {
+			if id = strings.TrimSpace(id); id != "" {
+				f.IDs = append(f.IDs, id)
+			}
+		}
+	}
+	if len(f.IDs) > maxFilterIDs {
+		writeProblem(w, r, problemInvalidParameter, fmt.Sprintf("ids lists more than %d IDs", maxFilterIDs))
+		return f, false
+	}
+	for _, tag := range r.URL.Query()["tag"] {
+		f.Tags = append(f.Tags, strings.ToLower(strings.TrimSpace(tag)))
+	}
+	f.Album = r.URL.Query().Get("album")
+	return f, true
+}
+
+// filterClause renders the filter as SQL to follow the FROM clause and joins
+// of a query on cat_pics aliased as p, along with its arguments. An album
+// filter joins album_pics as ap. Pictures in the trash never match.
+func (f catPicFilter) filterClause() (string, []interface{}) {
+	var join string
+	conds := []string{"p.deleted_at IS NULL"}
+	var args []interface{}
+
+	if f.Album != "" {
+		join = " JOIN album_pics ap ON ap.cat_pic_id = p.id AND ap.album_id = ?"
+		args = append(args, f.Album)
+	}
+
+	if len(f.IDs) > 0 {
+		conds = append(conds, "p.id IN (?"+strings.Repeat(", ?", len(f.IDs)-1)+")")
+		for _, id := range f.IDs {
+			args = append(args, id)
+		}
+	}
+
+	for _, tag := range f.Tags {
+		conds = append(conds, "p.id IN (SELECT ct.cat_pic_id FROM cat_pic_tags ct JOIN tags t ON t.id = ct.tag_id WHERE t.name = ?)")
+		args = append(args, tag)
+	}
+
+	return join + " WHERE " + strings.Join(conds, " AND "), args
+}
+
+// orderClause returns the ORDER BY clause for the filter: the album's order
+// when filtering by album, and otherwise fallback.
+func (f catPicFilter) orderClause(fallback string) string {
+	if f.Album != "" {
+		return " ORDER BY ap.position"
+	}
+	return fallback
+}
+
 // getCatPicByID godoc
 // @Summary Get a cat picture by ID
-// @Description Get a cat picture by its unique ID
+// @Description Get a cat picture by its unique ID. The picture is served in its stored format unless the Accept header or the format parameter asks for another one, in which case it is transcoded.
 // @Tags catpics
 // @Accept  json
-// @Produce  jpeg
-// @Param   id   path  string  true  "Cat Picture ID"
-// @Success 200  {object}  CatPicResponse
-// @Failure 404  {object}  map[string]string
-// @Router /catpics/{id} [get]
+// @Produce  jpeg,png,gif
+// @Param   id       path    string  true   "Cat Picture ID"
+// @Param   format   query   string  false  "Format to convert to, overriding the Accept header" Enums(png, jpeg, gif)
+// @Param   quality  query   int     false  "JPEG quality (1-100, default 90)"
+// @Param   Accept   header  string  false  "Acceptable image types, e.g. image/png"
+// @Success 200  {file}    binary
+// @Header  200  {string}  ETag  "Picture version, for If-Match; only set when the stored format is served"
+// @Failure 400  {object}  Problem            "Invalid format or quality"
+// @Failure 404  {object}  Problem
+// @Failure 406  {object}  Problem            "None of the accepted formats can be produced"
+// @Failure 422  {object}  Problem            "Picture cannot be decoded for conversion or exceeds the dimension limits"
+// @Failure 500  {object}  Problem            "Internal Server Error"
+// @Router /v1/catpics/{id}/data [get]
 func GetCatPicByID(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
+		query := r.URL.Query()
+		w.Header().Set("Vary", "Accept")
 
+		format := strings.ToLower(query.Get("format"))
+		if format != "" && formatTypes[format] == "" {
+			writeProblem(w, r, problemInvalidParameter, "format must be png, jpeg or gif")
+			return
+		}
+		quality := reencodeJPEGQuality
+		if v := query.Get("quality"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > 100 {
+				writeProblem(w, r, problemInvalidParameter, "quality must be an integer between 1 and 100")
+				return
+			}
+			quality = n
+		}
+
+		var hash string
 		var data []byte
-		err := db.QueryRow("SELECT data FROM cat_pics WHERE id = ?", id).Scan(&data)
+		var version int
+		err := traced(r.Context(), db).QueryRow(`SELECT b.hash, b.data, p.version FROM cat_pics p JOIN blobs b ON b.hash = p.hash
+			WHERE p.id = ? AND p.deleted_at IS NULL`, id).Scan(&hash, &data, &version)
 		switch {
 		case err == sql.ErrNoRows:
-			http.NotFound(w, r)
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
 		case err != nil:
-			log.Printf("Error querying database: %v", err)
-			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
-		default:
-			w.Header().Set("Content-Type", "image/jpeg")
-			if _, err := w.Write(data); err != nil {
-				log.Printf("Error writing image to response: %v", err)
-				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			serverError(w, r, "Error querying database", err)
+			return
+		}
+
+		original := http.DetectContentType(data)
+		contentType := formatTypes[format]
+		if contentType == "" {
+			contentType, err = negotiateType(r.Header.Get("Accept"), original)
+			if err != nil {
+				writeProblem(w, r, problemNotAcceptable, "None of the accepted formats can be produced")
+				return
 			}
 		}
+
+		// An explicit quality re-encodes JPEGs even when they are stored as JPEG.
+		if contentType != original || (contentType == "image/jpeg" && query.Has("quality")) {
+			key := hash + "|" + contentType + "|" + strconv.Itoa(quality)
+			converted, ok := convertedImages.get(key)
+			if !ok {
+				_, span := startSpan(r.Context(), "convertImage")
+				converted, err = convertImage(data, contentType, quality)
+				endSpan(span, err)
+				if errors.Is(err, errImageTooLarge) {
+					writeProblem(w, r, problemImageTooLarge, imageTooLargeMessage(err))
+					return
+				}
+				if err != nil {
+					writeProblem(w, r, problemUnconvertibleImage, "Cat picture cannot be converted")
+					return
+				}
+				convertedImages.put(key, converted)
+			}
+			data = converted
+		} else {
+			// Converted representations differ from the picture the ETag
+			// names, so only the stored bytes carry it.
+			w.Header().Set("ETag", pictureETag(version))
+			data = servedImage(data)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(data); err != nil {
+			logError(r, "Error writing image to response", err)
+		}
 	}
 }
 
@@ -135,166 +462,364 @@ func jsonResponse(w http.ResponseWriter, data interface{}, statusCode int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
 	if err := json.NewEncoder(w).Encode(data); err != nil {
-		log.Printf("Error encoding JSON response: %v", err)
+		slog.Error("Error encoding JSON response", "error", err)
+	}
+}
+
+const maxJSONBodySize = 1 << 20 // 1 MB
+
+// decodeJSONBody decodes a JSON request body into v.
+func decodeJSONBody(w http.ResponseWriter, r *http.Request, v interface{}) error {
+	return json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJSONBodySize)).Decode(v)
+}
+
+var errFileTooLarge = errors.New("file too large")
+
+// imageTooLargeMessage turns an errImageTooLarge error into a response message.
+func imageTooLargeMessage(err error) string {
+	msg := err.Error()
+	return strings.ToUpper(msg[:1]) + msg[1:]
+}
+
+// validateCatPic applies the rules every stored cat picture must satisfy.
+func validateCatPic(data []byte) error {
+	if len(data) > maxUploadSize {
+		return errFileTooLarge
+	}
+	// Files that are not decodable images are stored as they are.
+	if cfg, _, err := image.DecodeConfig(bytes.NewReader(data)); err == nil {
+		return checkImageSize(cfg.Width, cfg.Height)
 	}
+	return nil
 }
 
-func jsonError(w http.ResponseWriter, message string, statusCode int) {
-	jsonResponse(w, map[string]string{"error": message}, statusCode)
+// prepareCatPicTraced runs prepareCatPic in a span of ctx's trace.
+func prepareCatPicTraced(ctx context.Context, data []byte) []byte {
+	_, span := startSpan(ctx, "prepareCatPic")
+	defer span.End()
+	return prepareCatPic(data)
+}
+
+// prepareCatPic rewrites uploaded bytes into the form they are stored in.
+func prepareCatPic(data []byte) []byte {
+	if config.AutoOrient {
+		data = autoOrient(data)
+	}
+	if config.StripSensitiveExif {
+		data = stripSensitiveExif(data)
+	}
+	return data
 }
 
 // createCatPic godoc
 // @Summary Create a cat picture
-// @Description Add a new cat picture to the collection
+// @Description Add a new cat picture to the collection. Clients that retry uploads should send an Idempotency-Key: retries with the same key and request get the original response, headers included (marked with Idempotent-Replayed: true), for CATPICS_IDEMPOTENCY_TTL instead of creating another picture.
 // @Tags catpics
 // @Accept  mpfd
 // @Produce  json
-// @Param   catpic   formData  file  true  "Cat Picture"
-// @Success 201  {object}  CatPic
-// @Failure 400  {object}  map[string]string
-// @Failure 413  {object}  map[string]string
-// @Router /catpics [post]
+// @Param   catpic           formData  file  true  "Cat Picture"
+// @Param   tags             formData  []string  false  "Tags to attach (repeated or comma-separated)" collectionFormat(multi)
+// @Param   Idempotency-Key  header    string  false  "Unique key for this upload, at most 255 characters"
+// @Success 201  {object}  CatPicEnvelope
+// @Header  201  {string}  Location  "Path of the new picture"
+// @Success 200  {object}  CatPicEnvelope  "Identical picture already stored (CATPICS_DEDUP_MODE=reuse)"
+// @Header  200,201  {string}  ETag  "Picture version, for If-Match"
+// @Failure 400  {object}  Problem
+// @Failure 413  {object}  Problem
+// @Failure 422  {object}  Problem            "Image dimensions exceed the configured limits, or the Idempotency-Key was used for a different request"
+// @Router /v1/catpics [post]
 func CreateCatPic(db *sql.DB) http.HandlerFunc {
     return func(w http.ResponseWriter, r *http.Request) {
+        key := r.Header.Get("Idempotency-Key")
+        if len(key) > maxIdempotencyKeyLength {
+            writeProblem(w, r, problemInvalidIdempotencyKey, "Idempotency-Key is too long")
+            return
+        }
+
         if r.ContentLength > maxUploadSize {
-            jsonError(w, "File too large", http.StatusRequestEntityTooLarge)
+            writeProblem(w, r, problemUploadTooLarge, "File too large")
             return
         }
 
         r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize+1)
         
         if err := r.ParseMultipartForm(maxUploadSize); err != nil {
-            jsonError(w, "File too large", http.StatusRequestEntityTooLarge)
+            writeProblem(w, r, problemUploadTooLarge, "File too large")
             return
         }
 
         file, _, err := r.FormFile("catpic")
         if err != nil {
-            jsonError(w, "Invalid file", http.StatusBadRequest)
+            writeProblem(w, r, problemInvalidUpload, "Invalid file")
             return
         }
         defer file.Close()
 
         fileBytes, err := io.ReadAll(file)
         if err != nil {
-            jsonError(w, "Error reading file", http.StatusBadRequest)
+            writeProblem(w, r, problemInvalidUpload, "Error reading file")
+            return
+        }
+        observeUpload("create", len(fileBytes))
+
+        fingerprint := uploadFingerprint(fileBytes, r.MultipartForm.Value["tags"])
+        if isV1(r) {
+            // The versions respond differently, so a key cannot be shared.
+            fingerprint = "v1:" + fingerprint
+        }
+        if key != "" && replayIdempotencyKey(w, r, db, key, fingerprint) {
             return
         }
+        // saveResponse stores the response for key in the transaction that
+        // creates it, so a retry never sees the picture without it.
+        saveResponse := func(tx dbtx, status int, header http.Header, v interface{}) error {
+            if key == "" {
+                return nil
+            }
+            resp, err := newIdempotentResponse(fingerprint, status, header, v)
+            if err != nil {
+                return err
+            }
+            return saveIdempotencyKey(tx, key, resp, time.Now())
+        }
 
-        id := uuid.NewString()
+        switch err := validateCatPic(fileBytes); {
+        case errors.Is(err, errFileTooLarge):
+            writeProblem(w, r, problemUploadTooLarge, "File too large")
+            return
+        case errors.Is(err, errImageTooLarge):
+            writeProblem(w, r, problemImageTooLarge, imageTooLargeMessage(err))
+            return
+        case err != nil:
+            writeProblem(w, r, problemInvalidUpload, "Invalid file")
+            return
+        }
+        fileBytes = prepareCatPicTraced(r.Context(), fileBytes)
 
-        stmt, err := db.Prepare("INSERT INTO cat_pics (id, data) VALUES (?, ?)")
+        tags, err := parseTagList(r.MultipartForm.Value["tags"])
         if err != nil {
-            jsonError(w, "Error preparing database operation", http.StatusInternalServerError)
+            writeProblem(w, r, problemInvalidTagName, "Invalid tag name")
             return
         }
-        defer stmt.Close()
 
-        _, err = stmt.Exec(id, fileBytes)
+        id := newID()
+
+        // In reuse mode the lookup runs in the transaction that inserts, so
+        // concurrent identical uploads cannot both miss and both insert.
+        var reused bool
+        var body interface{}
+        status, header := http.StatusCreated, http.Header{}
+        err = withTx(r.Context(), db, func(tx *sql.Tx) error {
+            q := traced(r.Context(), tx)
+            if config.DedupMode == dedupReuse {
+                existingID, err := findCatPicByHash(q, contentHash(fileBytes))
+                switch {
+                case err == nil:
+                    id, reused = existingID, true
+                case err != sql.ErrNoRows:
+                    return err
+                }
+            }
+            if !reused {
+                if err := insertCatPic(q, id, fileBytes); err != nil {
+                    return err
+                }
+            }
+            if err := tagCatPic(q, id, tags); err != nil {
+                return err
+            }
+            if body, err = catPicBody(q, r, id); err != nil {
+                return err
+            }
+            version, err := currentVersion(q, id)
+            if err != nil {
+                return err
+            }
+            header.Set("ETag", pictureETag(version))
+            if reused {
+                status = http.StatusOK
+            } else if isV1(r) {
+                header.Set("Location", catPicPath(r, id))
+            }
+            return saveResponse(q, status, header, body)
+        })
+        if errors.Is(err, errIdempotencyKeyInUse) && replayIdempotencyKey(w, r, db, key, fingerprint) {
+            return
+        }
         if err != nil {
-            jsonError(w, "Error executing database operation", http.StatusInternalServerError)
+            serverError(w, r, "Error executing database operation", err)
             return
         }
 
-        jsonResponse(w, map[string]string{"id": id}, http.StatusCreated)
+        for name, values := range header {
+            w.Header()[name] = values
+        }
+        jsonResponse(w, body, status)
     }
 }
 
 // updateCatPic godoc
-// @Summary Update a cat picture
-// @Description Update an existing cat picture with new image data
+// @Summary Create or update a cat picture
+// @Description Replace the image data of a cat picture, or create the picture under the given ID if there is none, so clients can choose IDs. IDs are 1 to 64 letters, digits, - and _. Send the ETag from GET /v1/catpics/{id} in If-Match to make sure nobody else updated the picture in the meantime, or If-None-Match: * to only create it; CATPICS_REQUIRE_IF_MATCH makes one of them mandatory.
 // @Tags catpics
 // @Accept  mpfd
 // @Produce  json
-// @Param   id      path     string                 true  "Cat Picture ID"
-// @Param   catpic  formData file                  true  "New Cat Picture"
-// @Success 200     {string} string                "ok"
-// @Failure 400     {object} map[string]string     "Bad Request"
-// @Failure 404     {object} map[string]string     "Not Found"
-// @Failure 500     {object} map[string]string     "Internal Server Error"
-// @Router /catpics/{id} [put]
+// @Param   id             path     string                 true  "Cat Picture ID"
+// @Param   catpic         formData file                  true  "New Cat Picture"
+// @Param   If-Match       header   string                false "ETag or version number the picture must still have"
+// @Param   If-None-Match  header   string                false "* to fail if the picture exists"
+// @Success 200     {object} CatPicEnvelope        "Updated"
+// @Success 201     {object} CatPicEnvelope        "Created"
+// @Header  200,201 {string} ETag                  "ETag of the new version"
+// @Failure 400     {object} Problem               "Bad Request"
+// @Failure 409     {object} Problem               "A picture with this ID is in the trash"
+// @Failure 412     {object} Problem               "A precondition failed"
+// @Failure 413     {object} Problem               "File too large"
+// @Failure 422     {object} Problem               "Image dimensions exceed the configured limits"
+// @Failure 428     {object} Problem               "If-Match is required"
+// @Failure 500     {object} Problem               "Internal Server Error"
+// @Router /v1/catpics/{id} [put]
 func UpdateCatPic(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
+		if !validCatPicID(id) {
+			writeProblem(w, r, problemInvalidID, "Invalid cat picture ID")
+			return
+		}
+		if !requireIfMatch(w, r) {
+			return
+		}
 
 		if err := r.ParseMultipartForm(10 << 20); err != nil {
-			jsonError(w, "File too large or invalid", http.StatusBadRequest)
+			writeProblem(w, r, problemInvalidUpload, "File too large or invalid")
 			return
 		}
 
 		file, _, err := r.FormFile("catpic")
 		if err != nil {
-			jsonError(w, "Invalid file", http.StatusBadRequest)
+			writeProblem(w, r, problemInvalidUpload, "Invalid file")
 			return
 		}
 		defer file.Close()
 
 		fileBytes, err := ioutil.ReadAll(file)
 		if err != nil {
-			jsonError(w, "Invalid file", http.StatusBadRequest)
+			writeProblem(w, r, problemInvalidUpload, "Invalid file")
 			return
 		}
+		observeUpload("update", len(fileBytes))
 
-		stmt, err := db.Prepare("UPDATE cat_pics SET data = ? WHERE id = ?")
-		if err != nil {
-			jsonError(w, "Error preparing SQL statement", http.StatusInternalServerError)
+		switch err := validateCatPic(fileBytes); {
+		case errors.Is(err, errFileTooLarge):
+			writeProblem(w, r, problemUploadTooLarge, "File too large")
+			return
+		case errors.Is(err, errImageTooLarge):
+			writeProblem(w, r, problemImageTooLarge, imageTooLargeMessage(err))
+			return
+		case err != nil:
+			writeProblem(w, r, problemInvalidUpload, "Invalid file")
 			return
 		}
-		defer stmt.Close()
-
-		result, err := stmt.Exec(fileBytes, id)
+		fileBytes = prepareCatPicTraced(r.Context(), fileBytes)
+
+		var found, matched, trashed bool
+		var version int
+		var body interface{}
+		err = withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			found, matched, err = checkPreconditions(q, id, r)
+			if err != nil || !matched {
+				return err
+			}
+			if !found {
+				// The ID of a trashed picture stays taken until it is purged.
+				if trashed, err = catPicTrashed(q, id); err != nil || trashed {
+					return err
+				}
+				version = 1
+				if err := insertCatPic(q, id, fileBytes); err != nil {
+					return err
+				}
+			} else {
+				if _, err := replaceCatPicData(q, id, fileBytes); err != nil {
+					return err
+				}
+				if version, err = currentVersion(q, id); err != nil {
+					return err
+				}
+			}
+			body, err = catPicBody(q, r, id)
+			return err
+		})
 		if err != nil {
-			jsonError(w, "Error updating the cat picture", http.StatusInternalServerError)
+			serverError(w, r, "Error updating the cat picture", err)
 			return
 		}
 
-		rowsAffected, err := result.RowsAffected()
-		if err != nil {
-			jsonError(w, "Error checking update result", http.StatusInternalServerError)
+		if trashed {
+			writeProblem(w, r, problemCatPicTrashed, "Cat picture is in the trash")
 			return
 		}
-
-		if rowsAffected == 0 {
-			jsonError(w, "Cat picture not found", http.StatusNotFound)
+		if !matched {
+			writeProblem(w, r, problemPreconditionFailed, "Cat picture has been modified")
 			return
 		}
 
-		jsonResponse(w, "Cat picture updated successfully", http.StatusOK)
+		w.Header().Set("ETag", pictureETag(version))
+		if !found {
+			w.Header().Set("Location", catPicPath(r, id))
+			jsonResponse(w, body, http.StatusCreated)
+			return
+		}
+		jsonResponse(w, body, http.StatusOK)
 	}
 }
 
 // deleteCatPic godoc
 // @Summary Delete a cat picture
-// @Description Delete a cat picture by its unique identifier
+// @Description Move a cat picture to the trash. It can be restored until it is purged after the retention period (CATPICS_TRASH_RETENTION). If-Match works as for updates.
 // @Tags catpics
 // @Accept  json
 // @Produce  json
-// @Param   id  path  string  true  "Cat Picture ID"
+// @Param   id        path    string  true   "Cat Picture ID"
+// @Param   If-Match  header  string  false  "ETag or version number the picture must still have"
 // @Success 204 "No Content"
-// @Failure 400 {object} map[string]string "Invalid request"
-// @Failure 404 {object} map[string]string "Not Found"
-// @Failure 500 {object} map[string]string "Internal Server Error"
-// @Router /catpics/{id} [delete]
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 412 {object} Problem "The picture no longer matches If-Match"
+// @Failure 428 {object} Problem "If-Match is required"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id} [delete]
 func DeleteCatPic(db *sql.DB) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		vars := mux.Vars(r)
 		id := vars["id"]
-
-		result, err := db.Exec("DELETE FROM cat_pics WHERE id = ?", id)
-		if err != nil {
-			jsonError(w, "Server error", http.StatusInternalServerError)
+		if !requireIfMatch(w, r) {
 			return
 		}
 
-		rowsAffected, err := result.RowsAffected()
+		var found, matched bool
+		err := withTx(r.Context(), db, func(tx *sql.Tx) (err error) {
+			q := traced(r.Context(), tx)
+			found, matched, err = checkPreconditions(q, id, r)
+			if err != nil || !found || !matched {
+				return err
+			}
+			_, err = trashCatPic(q, id, time.Now())
+			return err
+		})
 		if err != nil {
-			jsonError(w, "Error checking deletion result", http.StatusInternalServerError)
+			serverError(w, r, "Server error", err)
 			return
 		}
 
-		if rowsAffected == 0 {
-			jsonError(w, "Cat picture not found", http.StatusNotFound)
+		if !found {
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		}
+		if !matched {
+			writeProblem(w, r, problemPreconditionFailed, "Cat picture has been modified")
 			return
 		}
 
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "catpics_http_requests_total",
+		Help: "HTTP requests served, by method, route template and status.",
+	}, []string{"method", "route", "status"})
+
+	httpRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "catpics_http_request_duration_seconds",
+		Help:    "Time taken to serve HTTP requests, by method, route template and status.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	uploadSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name: "catpics_upload_size_bytes",
+		Help: "Size of uploaded pictures, by operation.",
+		// 16 KiB to 16 MiB, which covers the 10 MB upload limit.
+		Buckets: prometheus.ExponentialBuckets(16<<10, 4, 6),
+	}, []string{"operation"})
+)
+
+// observeRequest records a served request. Requests that matched no route
+// share one label value so that arbitrary paths cannot blow up the number of
+// series.
+func observeRequest(method, route string, status int, elapsed time.Duration) {
+	if route == "" {
+		route = "unmatched"
+	}
+	labels := prometheus.Labels{"method": method, "route": route, "status": strconv.Itoa(status)}
+	httpRequests.With(labels).Inc()
+	httpRequestDuration.With(labels).Observe(elapsed.Seconds())
+}
+
+// observeUpload records the size of a picture uploaded by operation.
+func observeUpload(operation string, size int) {
+	uploadSize.WithLabelValues(operation).Observe(float64(size))
+}
+
+// storageCollector reports the number of stored pictures and the bytes they
+// take up, queried at scrape time.
+type storageCollector struct {
+	db       *sql.DB
+	pictures *prometheus.Desc
+	bytes    *prometheus.Desc
+}
+
+func newStorageCollector(db *sql.DB) *storageCollector {
+	return &storageCollector{
+		db: db,
+		pictures: prometheus.NewDesc("catpics_pictures",
+			"Stored cat pictures, by whether they are in the trash.", []string{"state"}, nil),
+		bytes: prometheus.NewDesc("catpics_blob_bytes",
+			"Total size of the stored picture data, counting bytes shared by identical pictures and versions once.", nil, nil),
+	}
+}
+
+func (c *storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.pictures
+	ch <- c.bytes
+}
+
+func (c *storageCollector) Collect(ch chan<- prometheus.Metric) {
+	var live, trashed, size int64
+	err := c.db.QueryRow(`SELECT
+		(SELECT COUNT(*) FROM cat_pics WHERE deleted_at IS NULL),
+		(SELECT COUNT(*) FROM cat_pics WHERE deleted_at IS NOT NULL),
+		(SELECT COALESCE(SUM(length(data)), 0) FROM blobs)`).Scan(&live, &trashed, &size)
+	if err != nil {
+		slog.Error("Error collecting storage metrics", "error", err)
+		ch <- prometheus.NewInvalidMetric(c.pictures, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.pictures, prometheus.GaugeValue, float64(live), "live")
+	ch <- prometheus.MustNewConstMetric(c.pictures, prometheus.GaugeValue, float64(trashed), "trashed")
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(size))
+}
+
+// metricsHandler serves the service's metrics, along with Go runtime,
+// process and database connection pool metrics, in the Prometheus text
+// format.
+func metricsHandler(db *sql.DB) http.Handler {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		httpRequests,
+		httpRequestDuration,
+		uploadSize,
+		newStorageCollector(db),
+		collectors.NewDBStatsCollector(db, "catpics"),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// migrations upgrade the schema one step at a time. The number of applied
+// migrations is tracked in SQLite's user_version pragma, so entries must only
+// ever be appended.
+var migrations = []func(tx *sql.Tx) error{
+	createCatPicsTable,
+	moveDataToBlobs,
+	addPerceptualHashes,
+	addTagsAndAlbums,
+	addCaptions,
+	addExifMetadata,
+	addAnimationMetadata,
+	addPlaceholders,
+	addSoftDelete,
+	addVersions,
+	addIdempotencyKeys,
+	dropSearchTriggers,
+	addIdempotentHeaders,
+	addSimilarityGeneration,
+	logSimilarityChanges,
+	addSearchIndexState,
+}
+
+// migrate applies every migration the database has not seen yet.
+func migrate(db *sql.DB) error {
+	var version int
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if err := migrations[version](tx); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %w", version+1, err)
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
+			tx.Rollback()
+			return err
+		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+	}
+
+	return ensureSearchIndex(db)
+}
+
+func createCatPicsTable(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE IF NOT EXISTS cat_pics (id TEXT PRIMARY KEY, data BLOB NOT NULL);")
+	return err
+}
+
+// moveDataToBlobs moves picture bytes into the content-addressed blobs table
+// so identical uploads share storage.
+func moveDataToBlobs(tx *sql.Tx) error {
+	if _, err := tx.Exec("CREATE TABLE blobs (hash TEXT PRIMARY KEY, data BLOB NOT NULL, ref_count INTEGER NOT NULL);"); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec("ALTER TABLE cat_pics ADD COLUMN hash TEXT REFERENCES blobs(hash);"); err != nil {
+		return err
+	}
+
+	var ids []string
+	rows, err := tx.Query("SELECT id FROM cat_pics")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		var data []byte
+		if err := tx.QueryRow("SELECT data FROM cat_pics WHERE id = ?", id).Scan(&data); err != nil {
+			return err
+		}
+
+		hash, err := putBlob(tx, data)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE cat_pics SET hash = ? WHERE id = ?", hash, id); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.Exec("ALTER TABLE cat_pics DROP COLUMN data;"); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec("CREATE INDEX cat_pics_hash ON cat_pics (hash);")
+	return err
+}
+
+// addPerceptualHashes adds the phash column used for near-duplicate search and
+// fills it in for pictures stored before it existed.
+func addPerceptualHashes(tx *sql.Tx) error {
+	if _, err := tx.Exec("ALTER TABLE cat_pics ADD COLUMN phash INTEGER;"); err != nil {
+		return err
+	}
+
+	return backfillFromBlobs(tx, "phash = ?", func(data []byte) []interface{} {
+		return []interface{}{phashValue(data)}
+	})
+}
+
+// backfillFromBlobs fills columns derived from picture bytes for every stored
+// picture. set is the SET clause and values returns its arguments for a blob.
+func backfillFromBlobs(tx *sql.Tx, set string, values func(data []byte) []interface{}) error {
+	var hashes []string
+	rows, err := tx.Query("SELECT hash FROM blobs")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var hash string
+		if err := rows.Scan(&hash); err != nil {
+			rows.Close()
+			return err
+		}
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, hash := range hashes {
+		var data []byte
+		if err := tx.QueryRow("SELECT data FROM blobs WHERE hash = ?", hash).Scan(&data); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("UPDATE cat_pics SET "+set+" WHERE hash = ?", append(values(data), hash)...); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func addTagsAndAlbums(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"CREATE TABLE tags (id INTEGER PRIMARY KEY, name TEXT NOT NULL UNIQUE);",
+		"CREATE TABLE cat_pic_tags (cat_pic_id TEXT NOT NULL REFERENCES cat_pics(id), tag_id INTEGER NOT NULL REFERENCES tags(id), PRIMARY KEY (cat_pic_id, tag_id));",
+		"CREATE INDEX cat_pic_tags_tag ON cat_pic_tags (tag_id);",
+		"CREATE TABLE albums (id TEXT PRIMARY KEY, name TEXT NOT NULL);",
+		"CREATE TABLE album_pics (album_id TEXT NOT NULL REFERENCES albums(id), cat_pic_id TEXT NOT NULL REFERENCES cat_pics(id), position INTEGER NOT NULL, PRIMARY KEY (album_id, cat_pic_id));",
+		"CREATE INDEX album_pics_cat_pic ON album_pics (cat_pic_id);",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addCaptions(tx *sql.Tx) error {
+	for _, column := range []string{"title", "caption", "alt_text"} {
+		if _, err := tx.Exec("ALTER TABLE cat_pics ADD COLUMN " + column + " TEXT NOT NULL DEFAULT '';"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addExifMetadata(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"ALTER TABLE cat_pics ADD COLUMN camera TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE cat_pics ADD COLUMN taken_at TEXT NOT NULL DEFAULT '';",
+		"ALTER TABLE cat_pics ADD COLUMN orientation INTEGER NOT NULL DEFAULT 0;",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+
+	return backfillFromBlobs(tx, "camera = ?, taken_at = ?, orientation = ?", func(data []byte) []interface{} {
+		exif := readExif(data)
+		return []interface{}{exif.Camera, exif.TakenAt, exif.Orientation}
+	})
+}
+
+func addAnimationMetadata(tx *sql.Tx) error {
+	for _, column := range []string{"frame_count", "duration_ms", "loop_count"} {
+		if _, err := tx.Exec("ALTER TABLE cat_pics ADD COLUMN " + column + " INTEGER NOT NULL DEFAULT 0;"); err != nil {
+			return err
+		}
+	}
+
+	return backfillFromBlobs(tx, "frame_count = ?, duration_ms = ?, loop_count = ?", func(data []byte) []interface{} {
+		anim, _ := readGIFAnimation(data)
+		return []interface{}{anim.Frames, anim.DurationMs, anim.LoopCount}
+	})
+}
+
+func addPlaceholders(tx *sql.Tx) error {
+	for _, column := range []string{"palette", "blur_hash"} {
+		if _, err := tx.Exec("ALTER TABLE cat_pics ADD COLUMN " + column + " TEXT NOT NULL DEFAULT '';"); err != nil {
+			return err
+		}
+	}
+
+	return backfillFromBlobs(tx, "palette = ?, blur_hash = ?", func(data []byte) []interface{} {
+		img, _, err := decodeImage(data)
+		if err != nil {
+			return []interface{}{"", ""}
+		}
+		thumb := thumbnail(img, placeholderSize)
+		return []interface{}{strings.Join(dominantColors(thumb, paletteSize), ","), blurHash(thumb, blurHashX, blurHashY)}
+	})
+}
+
+func addSoftDelete(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"ALTER TABLE cat_pics ADD COLUMN deleted_at TEXT;",
+		"CREATE INDEX cat_pics_deleted_at ON cat_pics (deleted_at) WHERE deleted_at IS NOT NULL;",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addVersions(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"ALTER TABLE cat_pics ADD COLUMN version INTEGER NOT NULL DEFAULT 1;",
+		"CREATE TABLE cat_pic_versions (cat_pic_id TEXT NOT NULL REFERENCES cat_pics(id), version INTEGER NOT NULL, hash TEXT NOT NULL REFERENCES blobs(hash), replaced_at TEXT NOT NULL, PRIMARY KEY (cat_pic_id, version));",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func addIdempotencyKeys(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE idempotency_keys (key TEXT PRIMARY KEY, fingerprint TEXT NOT NULL, status INTEGER NOT NULL, body BLOB NOT NULL, created_at TEXT NOT NULL);")
+	return err
+}
+
+// dropSearchTriggers removes the triggers that used to keep the full-text
+// index in sync. Builds without FTS5 cannot run them, so they made databases
+// created by an FTS5 build read-only to those builds; indexCatPic does their
+// job now.
+func dropSearchTriggers(tx *sql.Tx) error {
+	for _, name := range []string{"cat_pics_fts_insert", "cat_pics_fts_update", "cat_pics_fts_delete"} {
+		if _, err := tx.Exec("DROP TRIGGER IF EXISTS " + name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addIdempotentHeaders stores the headers of idempotent responses, such as
+// Location, so that replays carry them too.
+func addIdempotentHeaders(tx *sql.Tx) error {
+	_, err := tx.Exec("ALTER TABLE idempotency_keys ADD COLUMN headers TEXT NOT NULL DEFAULT '{}';")
+	return err
+}
+
+// addSimilarityGeneration counts changes to the pictures that matter to the
+// similarity index. Plain triggers keep the count, so writes by any process
+// using the database, including ones that predate the index, are counted.
+func addSimilarityGeneration(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"CREATE TABLE similarity_generation (generation INTEGER NOT NULL);",
+		"INSERT INTO similarity_generation (generation) VALUES (0);",
+		"CREATE TRIGGER similarity_generation_insert AFTER INSERT ON cat_pics BEGIN UPDATE similarity_generation SET generation = generation + 1; END;",
+		"CREATE TRIGGER similarity_generation_update AFTER UPDATE OF phash, deleted_at ON cat_pics BEGIN UPDATE similarity_generation SET generation = generation + 1; END;",
+		"CREATE TRIGGER similarity_generation_delete AFTER DELETE ON cat_pics BEGIN UPDATE similarity_generation SET generation = generation + 1; END;",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logSimilarityChanges replaces the similarity generation counter with a log
+// of the pictures changed, so the index can apply just those. Plain triggers
+// keep the log, and only the latest 10000 entries are kept; an index that
+// falls further behind is rebuilt.
+func logSimilarityChanges(tx *sql.Tx) error {
+	for _, stmt := range []string{
+		"DROP TRIGGER similarity_generation_insert;",
+		"DROP TRIGGER similarity_generation_update;",
+		"DROP TRIGGER similarity_generation_delete;",
+		"DROP TABLE similarity_generation;",
+		"CREATE TABLE similarity_changes (seq INTEGER PRIMARY KEY AUTOINCREMENT, cat_pic_id TEXT NOT NULL);",
+		"CREATE TRIGGER similarity_changes_insert AFTER INSERT ON cat_pics BEGIN INSERT INTO similarity_changes (cat_pic_id) VALUES (NEW.id); END;",
+		"CREATE TRIGGER similarity_changes_update AFTER UPDATE OF phash, deleted_at ON cat_pics BEGIN INSERT INTO similarity_changes (cat_pic_id) VALUES (NEW.id); END;",
+		"CREATE TRIGGER similarity_changes_delete AFTER DELETE ON cat_pics BEGIN INSERT INTO similarity_changes (cat_pic_id) VALUES (OLD.id); END;",
+		"CREATE TRIGGER similarity_changes_prune AFTER INSERT ON similarity_changes BEGIN DELETE FROM similarity_changes WHERE seq <= NEW.seq - 10000; END;",
+	} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// addSearchIndexState records which definition the full-text index was
+// built with, so that it is only rebuilt when that changes or a build without
+// FTS5 may have left it behind.
+func addSearchIndexState(tx *sql.Tx) error {
+	_, err := tx.Exec("CREATE TABLE search_index_state (definition TEXT NOT NULL);")
+	return err
+}
+
+// searchIndexDefinition creates the full-text index of titles, captions and
+// alt text. Changing it, say for another tokenizer, rebuilds the index.
+const searchIndexDefinition = "CREATE VIRTUAL TABLE cat_pics_fts USING fts5 (id UNINDEXED, title, caption, alt_text);"
+
+// ensureSearchIndex builds the full-text index if this build has FTS5 and the
+// index was not built with the current definition. Builds without FTS5 cannot
+// keep the index up to date, so they clear the recorded definition instead,
+// and the next build with FTS5 rebuilds the index from cat_pics.
+func ensureSearchIndex(db *sql.DB) error {
+	if !fts5Enabled {
+		slog.Info("Full-text search disabled: built without the sqlite_fts5 tag")
+		_, err := db.Exec("DELETE FROM search_index_state;")
+		return err
+	}
+
+	return withTx(context.Background(), db, func(tx *sql.Tx) error {
+		var definition string
+		err := tx.QueryRow("SELECT definition FROM search_index_state").Scan(&definition)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+		if definition == searchIndexDefinition {
+			return nil
+		}
+
+		slog.Info("Rebuilding the full-text search index")
+		for _, stmt := range []string{
+			"DROP TABLE IF EXISTS cat_pics_fts;",
+			searchIndexDefinition,
+			"INSERT INTO cat_pics_fts (id, title, caption, alt_text) SELECT id, title, caption, alt_text FROM cat_pics;",
+			"DELETE FROM search_index_state;",
+		} {
+			if _, err := tx.Exec(stmt); err != nil {
+				return err
+			}
+		}
+		_, err = tx.Exec("INSERT INTO search_index_state (definition) VALUES (?)", searchIndexDefinition)
+		return err
+	})
+}
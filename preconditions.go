@@ -0,0 +1,58 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// pictureETag returns the entity tag of a picture at the given version.
+func pictureETag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// ifMatch reports whether an If-Match header is satisfied by a picture at
+// version. Entity tags and bare version numbers are both accepted. If-Match
+// uses strong comparison, so weak tags never match.
+func ifMatch(header string, version int) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || candidate == pictureETag(version) || candidate == strconv.Itoa(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// requireIfMatch writes a 428 and returns false if the configuration requires
+// a precondition and r has none. If-None-Match counts, as it is how a client
+// asks to create a picture only if it does not exist yet.
+func requireIfMatch(w http.ResponseWriter, r *http.Request) bool {
+	if config.RequireIfMatch && r.Header.Get("If-Match") == "" && r.Header.Get("If-None-Match") == "" {
+		writeProblem(w, r, problemPreconditionRequired, "If-Match header required")
+		return false
+	}
+	return true
+}
+
+// checkPreconditions evaluates the If-Match and If-None-Match headers of r
+// against the picture id outside the trash. A missing picture satisfies
+// anything but If-Match. Running it in the transaction that modifies the
+// picture keeps a concurrent update from slipping in between.
+func checkPreconditions(tx dbtx, id string, r *http.Request) (found, matched bool, err error) {
+	version, err := currentVersion(tx, id)
+	if err == sql.ErrNoRows {
+		return false, r.Header.Get("If-Match") == "", nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	if h := r.Header.Get("If-Match"); h != "" && !ifMatch(h, version) {
+		return true, false, nil
+	}
+	if h := r.Header.Get("If-None-Match"); h != "" && ifMatch(h, version) {
+		return true, false, nil
+	}
+	return true, true, nil
+}
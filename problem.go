@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/mux"
+)
+
+// problemContentType is the media type of error responses (RFC 7807).
+const problemContentType = "application/problem+json"
+
+// problemTypeBase prefixes the code of a problem type to form its URI, which
+// GetProblemType serves a description at.
+const problemTypeBase = "/problems/"
+
+// Problem is an RFC 7807 problem details object, the body of every error
+// response.
+type Problem struct {
+	// Type identifies the kind of problem and is the same for every
+	// occurrence of it.
+	Type  string `json:"type" example:"/problems/catpic-not-found"`
+	Title string `json:"title" example:"Cat picture not found"`
+	// Status repeats the HTTP status code of the response.
+	Status int `json:"status" example:"404"`
+	// Detail explains this occurrence of the problem.
+	Detail string `json:"detail,omitempty" example:"Cat picture not found"`
+	// Instance is the path of the request that failed.
+	Instance string `json:"instance,omitempty" example:"/catpics/c4t"`
+	// Code is the stable, machine-readable name of the problem type.
+	Code string `json:"code" example:"catpic-not-found"`
+}
+
+// problemType is a kind of error the API reports. Its code and title never
+// change, so that clients can rely on them.
+type problemType struct {
+	code   string
+	status int
+	title  string
+}
+
+var (
+	problemInvalidBody           = problemType{"invalid-body", http.StatusBadRequest, "Invalid request body"}
+	problemInvalidParameter      = problemType{"invalid-parameter", http.StatusBadRequest, "Invalid parameter"}
+	problemInvalidID             = problemType{"invalid-id", http.StatusBadRequest, "Invalid cat picture ID"}
+	problemInvalidTagName        = problemType{"invalid-tag-name", http.StatusBadRequest, "Invalid tag name"}
+	problemInvalidUpload         = problemType{"invalid-upload", http.StatusBadRequest, "Invalid upload"}
+	problemInvalidArchive        = problemType{"invalid-archive", http.StatusBadRequest, "Invalid archive"}
+	problemInvalidIdempotencyKey = problemType{"invalid-idempotency-key", http.StatusBadRequest, "Invalid Idempotency-Key"}
+	problemRouteNotFound         = problemType{"route-not-found", http.StatusNotFound, "No such resource"}
+	problemCatPicNotFound        = problemType{"catpic-not-found", http.StatusNotFound, "Cat picture not found"}
+	problemVersionNotFound       = problemType{"version-not-found", http.StatusNotFound, "Cat picture version not found"}
+	problemTagNotFound           = problemType{"tag-not-found", http.StatusNotFound, "Tag not found"}
+	problemAlbumNotFound         = problemType{"album-not-found", http.StatusNotFound, "Album not found"}
+	problemCatPicNotTagged       = problemType{"catpic-not-tagged", http.StatusNotFound, "Cat picture does not carry this tag"}
+	problemCatPicNotInAlbum      = problemType{"catpic-not-in-album", http.StatusNotFound, "Cat picture is not in this album"}
+	problemMethodNotAllowed      = problemType{"method-not-allowed", http.StatusMethodNotAllowed, "Method not allowed"}
+	problemNotAcceptable         = problemType{"not-acceptable", http.StatusNotAcceptable, "No acceptable format"}
+	problemCatPicTrashed         = problemType{"catpic-trashed", http.StatusConflict, "Cat picture is in the trash"}
+	problemTagExists             = problemType{"tag-exists", http.StatusConflict, "Tag already exists"}
+	problemVersionCurrent        = problemType{"version-current", http.StatusConflict, "Version is already current"}
+	problemPreconditionFailed    = problemType{"precondition-failed", http.StatusPreconditionFailed, "Cat picture has been modified"}
+	problemUploadTooLarge        = problemType{"upload-too-large", http.StatusRequestEntityTooLarge, "Upload too large"}
+	problemImageTooLarge         = problemType{"image-too-large", http.StatusUnprocessableEntity, "Image dimensions too large"}
+	problemUndecodableImage      = problemType{"undecodable-image", http.StatusUnprocessableEntity, "Cat picture is not a decodable image"}
+	problemUnconvertibleImage    = problemType{"unconvertible-image", http.StatusUnprocessableEntity, "Cat picture cannot be converted"}
+	problemTransformTooExpensive = problemType{"transform-too-expensive", http.StatusUnprocessableEntity, "Transformation too expensive"}
+	problemIdempotencyKeyReused  = problemType{"idempotency-key-reused", http.StatusUnprocessableEntity, "Idempotency-Key reused"}
+	problemPreconditionRequired  = problemType{"precondition-required", http.StatusPreconditionRequired, "If-Match header required"}
+	problemInternal              = problemType{"internal-error", http.StatusInternalServerError, "Internal server error"}
+	problemSearchUnavailable     = problemType{"search-unavailable", http.StatusNotImplemented, "Full-text search unavailable"}
+)
+
+// problemTypes lists every problem type by code.
+var problemTypes = map[string]problemType{}
+
+func init() {
+	for _, p := range []problemType{
+		problemInvalidBody, problemInvalidParameter, problemInvalidID, problemInvalidTagName,
+		problemInvalidUpload, problemInvalidArchive, problemInvalidIdempotencyKey,
+		problemRouteNotFound, problemCatPicNotFound, problemVersionNotFound, problemTagNotFound,
+		problemAlbumNotFound, problemCatPicNotTagged, problemCatPicNotInAlbum,
+		problemMethodNotAllowed, problemNotAcceptable,
+		problemCatPicTrashed, problemTagExists, problemVersionCurrent,
+		problemPreconditionFailed, problemUploadTooLarge,
+		problemImageTooLarge, problemUndecodableImage, problemUnconvertibleImage,
+		problemTransformTooExpensive, problemIdempotencyKeyReused,
+		problemPreconditionRequired, problemInternal, problemSearchUnavailable,
+	} {
+		problemTypes[p.code] = p
+	}
+}
+
+// problem returns the details of an occurrence of p while serving r.
+func (p problemType) problem(r *http.Request, detail string) Problem {
+	return Problem{
+		Type:     problemTypeBase + p.code,
+		Title:    p.title,
+		Status:   p.status,
+		Detail:   detail,
+		Instance: r.URL.Path,
+		Code:     p.code,
+	}
+}
+
+// writeProblem responds to r with an occurrence of p, explained by detail.
+func writeProblem(w http.ResponseWriter, r *http.Request, p problemType, detail string) {
+	w.Header().Set("Content-Type", problemContentType)
+	w.WriteHeader(p.status)
+	if err := json.NewEncoder(w).Encode(p.problem(r, detail)); err != nil {
+		logError(r, "Error encoding problem response", err)
+	}
+}
+
+// routeNotFound answers requests that match no route.
+func routeNotFound(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemRouteNotFound, "No resource at "+r.URL.Path)
+}
+
+// methodNotAllowed answers requests for a route that does not support their
+// method.
+func methodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeProblem(w, r, problemMethodNotAllowed, r.Method+" is not supported on "+r.URL.Path)
+}
+
+// getProblemType godoc
+// @Summary Describe an error type
+// @Description Describe the problem type an error response's type URI names
+// @Tags errors
+// @Produce  json
+// @Param   code  path  string  true  "Problem code"
+// @Success 200 {object} Problem
+// @Failure 404 {object} Problem "Unknown problem type"
+// @Router /problems/{code} [get]
+func GetProblemType(w http.ResponseWriter, r *http.Request) {
+	p, ok := problemTypes[mux.Vars(r)["code"]]
+	if !ok {
+		routeNotFound(w, r)
+		return
+	}
+	description := p.problem(r, "")
+	description.Instance = ""
+	jsonResponse(w, description, http.StatusOK)
+}
@@ -0,0 +1,7 @@
+//go:build !(sqlite_fts5 || fts5)
+
+package main
+
+// fts5Enabled reports whether SQLite is built with the FTS5 extension, which
+// full-text search needs. The driver's sqlite_fts5 build tag decides.
+const fts5Enabled = false
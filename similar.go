@@ -0,0 +1,322 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultSimilarDistance = 10
+	maxSimilarDistance     = 32
+)
+
+type SimilarCatPic struct {
+	ID       string `json:"id"`
+	Distance int    `json:"distance"`
+}
+
+// similarCatPics godoc
+// @Summary Find similar cat pictures
+// @Description List pictures whose perceptual hash is within maxDistance bits of the given picture's, closest first
+// @Tags catpics
+// @Produce  json
+// @Param   id           path   string  true   "Cat Picture ID"
+// @Param   maxDistance  query  int     false  "Maximum Hamming distance (0-32, default 10)"
+// @Success 200  {array}   SimilarCatPic
+// @Failure 400  {object}  Problem
+// @Failure 404  {object}  Problem
+// @Failure 422  {object}  Problem            "Picture is not a decodable image"
+// @Failure 500  {object}  Problem
+// @Router /v1/catpics/{id}/similar [get]
+func SimilarCatPics(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		maxDistance := defaultSimilarDistance
+		if v := r.URL.Query().Get("maxDistance"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxSimilarDistance {
+				writeProblem(w, r, problemInvalidParameter, "maxDistance must be an integer between 0 and 32")
+				return
+			}
+			maxDistance = n
+		}
+
+		var phash sql.NullInt64
+		err := traced(r.Context(), db).QueryRow("SELECT phash FROM cat_pics WHERE id = ? AND deleted_at IS NULL", id).Scan(&phash)
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		case err != nil:
+			serverError(w, r, "Server error", err)
+			return
+		case !phash.Valid:
+			writeProblem(w, r, problemUndecodableImage, "Cat picture is not a decodable image")
+			return
+		}
+
+		idx := similarityIndexFor(db)
+		if err := idx.load(traced(r.Context(), db)); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		matches := []SimilarCatPic{}
+		for _, m := range idx.search(uint64(phash.Int64), maxDistance) {
+			if m.ID != id {
+				matches = append(matches, m)
+			}
+		}
+
+		jsonResponse(w, matches, http.StatusOK)
+	}
+}
+
+// bkNode is a node of a BK-tree over Hamming distance. Pictures sharing the
+// exact same hash share a node.
+type bkNode struct {
+	hash     uint64
+	ids      map[string]struct{}
+	children map[int]*bkNode
+}
+
+// similarityIndex is an in-memory BK-tree of every picture's perceptual hash.
+// A query only descends into children whose edge distance is within the
+// search radius of the node's distance, so lookups stay far below a linear
+// scan for the small radii used here.
+//
+// Every change to a picture, by this process or another one such as the
+// import command, is logged in similarity_changes, and the next query applies
+// just the changed pictures to the index.
+type similarityIndex struct {
+	// update serializes bringing the index up to date, which reads the
+	// database without holding mu, so searches only wait while the changes
+	// are applied.
+	update sync.Mutex
+	mu     sync.RWMutex
+	loaded bool
+	seq    int64 // last similarity_changes entry applied
+	root   *bkNode
+	byID   map[string]uint64
+}
+
+var (
+	similarityIndexesMu sync.Mutex
+	similarityIndexes   = map[*sql.DB]*similarityIndex{}
+)
+
+// similarityIndexFor returns the index for db, creating an empty one on first use.
+func similarityIndexFor(db *sql.DB) *similarityIndex {
+	similarityIndexesMu.Lock()
+	defer similarityIndexesMu.Unlock()
+
+	idx, ok := similarityIndexes[db]
+	if !ok {
+		idx = &similarityIndex{byID: map[string]uint64{}}
+		similarityIndexes[db] = idx
+	}
+	return idx
+}
+
+// load brings the index up to date, filling it from the database the first
+// time and applying the logged changes after that.
+func (idx *similarityIndex) load(q dbtx) error {
+	idx.update.Lock()
+	defer idx.update.Unlock()
+
+	if idx.loaded {
+		applied, err := idx.applyChanges(q)
+		if err != nil || applied {
+			return err
+		}
+	}
+	return idx.rebuild(q)
+}
+
+// applyChanges updates the entries of the pictures changed since the last
+// entry applied. It reports false if the log no longer reaches back that far,
+// so the index has to be rebuilt.
+func (idx *similarityIndex) applyChanges(q dbtx) (bool, error) {
+	rows, err := q.Query("SELECT seq, cat_pic_id FROM similarity_changes WHERE seq > ? ORDER BY seq", idx.seq)
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+
+	last := idx.seq
+	changed := map[string]bool{}
+	for rows.Next() {
+		var seq int64
+		var id string
+		if err := rows.Scan(&seq, &id); err != nil {
+			return false, err
+		}
+		// Entries are numbered without gaps, so a gap means older ones
+		// were pruned before this index saw them.
+		if seq != last+1 {
+			return false, nil
+		}
+		last = seq
+		changed[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		return false, err
+	}
+	rows.Close()
+
+	// A picture changed again after its entry was read is logged again, so
+	// reading its current hash here is never too new.
+	hashes := map[string]sql.NullInt64{}
+	for id := range changed {
+		var phash sql.NullInt64
+		err := q.QueryRow("SELECT phash FROM cat_pics WHERE id = ? AND deleted_at IS NULL", id).Scan(&phash)
+		if err != nil && err != sql.ErrNoRows {
+			return false, err
+		}
+		hashes[id] = phash
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	for id, phash := range hashes {
+		idx.remove(id)
+		if phash.Valid {
+			idx.add(id, uint64(phash.Int64))
+		}
+	}
+	idx.seq = last
+	return true, nil
+}
+
+// rebuild fills a new tree from the database and swaps it in.
+func (idx *similarityIndex) rebuild(q dbtx) error {
+	// Read before the hashes, so changes in between are applied again
+	// rather than missed.
+	var seq int64
+	if err := q.QueryRow("SELECT COALESCE(MAX(seq), 0) FROM similarity_changes").Scan(&seq); err != nil {
+		return err
+	}
+
+	rows, err := q.Query("SELECT id, phash FROM cat_pics WHERE phash IS NOT NULL AND deleted_at IS NULL")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	fresh := &similarityIndex{byID: map[string]uint64{}}
+	for rows.Next() {
+		var id string
+		var phash int64
+		if err := rows.Scan(&id, &phash); err != nil {
+			return err
+		}
+		fresh.add(id, uint64(phash))
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.root, idx.byID = fresh.root, fresh.byID
+	idx.loaded, idx.seq = true, seq
+	return nil
+}
+
+// add inserts id under hash. The caller must hold idx.mu.
+func (idx *similarityIndex) add(id string, hash uint64) {
+	idx.byID[id] = hash
+
+	if idx.root == nil {
+		idx.root = newBKNode(hash, id)
+		return
+	}
+
+	node := idx.root
+	for {
+		d := hammingDistance(hash, node.hash)
+		if d == 0 {
+			node.ids[id] = struct{}{}
+			return
+		}
+
+		child, ok := node.children[d]
+		if !ok {
+			node.children[d] = newBKNode(hash, id)
+			return
+		}
+		node = child
+	}
+}
+
+// remove drops id from the index. Emptied nodes stay in the tree to keep
+// their subtrees reachable. The caller must hold idx.mu.
+func (idx *similarityIndex) remove(id string) {
+	hash, ok := idx.byID[id]
+	if !ok {
+		return
+	}
+	delete(idx.byID, id)
+
+	for node := idx.root; node != nil; {
+		d := hammingDistance(hash, node.hash)
+		if d == 0 {
+			delete(node.ids, id)
+			return
+		}
+		node = node.children[d]
+	}
+}
+
+// search returns every indexed picture within maxDistance of hash, ordered by
+// distance and then ID.
+func (idx *similarityIndex) search(hash uint64, maxDistance int) []SimilarCatPic {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var matches []SimilarCatPic
+	stack := []*bkNode{}
+	if idx.root != nil {
+		stack = append(stack, idx.root)
+	}
+
+	for len(stack) > 0 {
+		node := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		d := hammingDistance(hash, node.hash)
+		if d <= maxDistance {
+			for id := range node.ids {
+				matches = append(matches, SimilarCatPic{ID: id, Distance: d})
+			}
+		}
+
+		for edge, child := range node.children {
+			if edge >= d-maxDistance && edge <= d+maxDistance {
+				stack = append(stack, child)
+			}
+		}
+	}
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Distance != matches[j].Distance {
+			return matches[i].Distance < matches[j].Distance
+		}
+		return matches[i].ID < matches[j].ID
+	})
+	return matches
+}
+
+func newBKNode(hash uint64, id string) *bkNode {
+	return &bkNode{
+		hash:     hash,
+		ids:      map[string]struct{}{id: {}},
+		children: map[int]*bkNode{},
+	}
+}
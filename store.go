@@ -0,0 +1,277 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"time"
+)
+
+// dbtx is satisfied by both *sql.DB and *sql.Tx.
+type dbtx interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// withTx runs fn inside a transaction, committing only if fn succeeds. The
+// transaction is rolled back if ctx is cancelled before it commits.
+func withTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// contentHash returns the hex-encoded SHA-256 of data, which is the key of its blob.
+func contentHash(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// putBlob stores data, or takes another reference to an identical blob that is
+// already stored, and returns its hash.
+func putBlob(tx dbtx, data []byte) (string, error) {
+	hash := contentHash(data)
+	_, err := tx.Exec(`INSERT INTO blobs (hash, data, ref_count) VALUES (?, ?, 1)
+		ON CONFLICT (hash) DO UPDATE SET ref_count = ref_count + 1`, hash, data)
+	return hash, err
+}
+
+// releaseBlob drops a reference to a blob, deleting it once nothing refers to it.
+func releaseBlob(tx dbtx, hash string) error {
+	if _, err := tx.Exec("UPDATE blobs SET ref_count = ref_count - 1 WHERE hash = ?", hash); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM blobs WHERE hash = ? AND ref_count <= 0", hash)
+	return err
+}
+
+// catPicMeta is the metadata derived from a picture's bytes whenever they are stored.
+type catPicMeta struct {
+	PHash       sql.NullInt64
+	Camera      string
+	TakenAt     string
+	Orientation int
+	Animation   Animation // zero for anything but GIFs
+	Palette     []string  // dominant colours, most common first
+	BlurHash    string
+}
+
+// catPicMetaColumns lists the cat_pics columns holding a catPicMeta, in the
+// order of catPicMeta.values.
+var catPicMetaColumns = []string{"phash", "camera", "taken_at", "orientation", "frame_count", "duration_ms", "loop_count", "palette", "blur_hash"}
+
+func analyzeCatPic(data []byte) catPicMeta {
+	exif := readExif(data)
+	anim, _ := readGIFAnimation(data)
+	m := catPicMeta{
+		Camera:      exif.Camera,
+		TakenAt:     exif.TakenAt,
+		Orientation: exif.Orientation,
+		Animation:   anim,
+	}
+
+	// Everything derived from pixels shares a single decode.
+	if img, _, err := decodeImage(data); err == nil {
+		m.PHash = sql.NullInt64{Int64: int64(dHash(img)), Valid: true}
+		thumb := thumbnail(img, placeholderSize)
+		m.Palette = dominantColors(thumb, paletteSize)
+		m.BlurHash = blurHash(thumb, blurHashX, blurHashY)
+	}
+	return m
+}
+
+// analyzeCatPicTraced runs analyzeCatPic in a span of the trace tx runs in.
+func analyzeCatPicTraced(tx dbtx, data []byte) catPicMeta {
+	_, span := startSpan(queryContext(tx), "analyzeCatPic")
+	defer span.End()
+	return analyzeCatPic(data)
+}
+
+func (m catPicMeta) values() []interface{} {
+	return []interface{}{m.PHash, m.Camera, m.TakenAt, m.Orientation, m.Animation.Frames, m.Animation.DurationMs, m.Animation.LoopCount,
+		strings.Join(m.Palette, ","), m.BlurHash}
+}
+
+// insertCatPic stores a new cat picture under id.
+func insertCatPic(tx dbtx, id string, data []byte) error {
+	hash, err := putBlob(tx, data)
+	if err != nil {
+		return err
+	}
+
+	query := "INSERT INTO cat_pics (id, hash, " + strings.Join(catPicMetaColumns, ", ") + ") VALUES (?, ?" +
+		strings.Repeat(", ?", len(catPicMetaColumns)) + ")"
+	if _, err = tx.Exec(query, append([]interface{}{id, hash}, analyzeCatPicTraced(tx, data).values()...)...); err != nil {
+		return err
+	}
+	return indexCatPic(tx, id)
+}
+
+// phashValue returns the perceptual hash of data in the form stored in the
+// phash column, or NULL if data is not a decodable image.
+func phashValue(data []byte) sql.NullInt64 {
+	hash, ok := perceptualHash(data)
+	return sql.NullInt64{Int64: int64(hash), Valid: ok}
+}
+
+// findCatPicByHash returns the ID of a picture outside the trash whose bytes
+// hash to hash.
+func findCatPicByHash(q dbtx, hash string) (string, error) {
+	var id string
+	err := q.QueryRow("SELECT id FROM cat_pics WHERE hash = ? AND deleted_at IS NULL LIMIT 1", hash).Scan(&id)
+	return id, err
+}
+
+// loadCatPicData returns the bytes of the picture with the given id.
+func loadCatPicData(q dbtx, id string) ([]byte, error) {
+	_, data, err := loadCatPicBlob(q, id)
+	return data, err
+}
+
+// loadCatPicBlob returns the content hash and bytes of the picture with the
+// given id. Pictures in the trash are not found.
+func loadCatPicBlob(q dbtx, id string) (hash string, data []byte, err error) {
+	err = q.QueryRow("SELECT b.hash, b.data FROM cat_pics p JOIN blobs b ON b.hash = p.hash WHERE p.id = ? AND p.deleted_at IS NULL", id).Scan(&hash, &data)
+	return hash, data, err
+}
+
+// replaceCatPicData points an existing picture at new bytes and reports whether
+// the picture exists outside the trash. The previous bytes are kept as a
+// version, which holds on to their blob, unless nothing changed.
+func replaceCatPicData(tx dbtx, id string, data []byte) (bool, error) {
+	var oldHash string
+	var version int
+	err := tx.QueryRow("SELECT hash, version FROM cat_pics WHERE id = ? AND deleted_at IS NULL", id).Scan(&oldHash, &version)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if contentHash(data) == oldHash {
+		return true, nil
+	}
+
+	hash, err := putBlob(tx, data)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err := tx.Exec("INSERT INTO cat_pic_versions (cat_pic_id, version, hash, replaced_at) VALUES (?, ?, ?, ?)",
+		id, version, oldHash, time.Now().UTC().Format(time.RFC3339)); err != nil {
+		return false, err
+	}
+
+	query := "UPDATE cat_pics SET hash = ?, version = version + 1, " + strings.Join(catPicMetaColumns, " = ?, ") + " = ? WHERE id = ?"
+	args := append(append([]interface{}{hash}, analyzeCatPicTraced(tx, data).values()...), id)
+	if _, err := tx.Exec(query, args...); err != nil {
+		return false, err
+	}
+
+	return true, pruneVersions(tx, id, config.MaxVersions)
+}
+
+// pruneVersions drops all but the newest keep versions of a picture.
+func pruneVersions(tx dbtx, id string, keep int) error {
+	rows, err := tx.Query("SELECT version, hash FROM cat_pic_versions WHERE cat_pic_id = ? ORDER BY version DESC LIMIT -1 OFFSET ?", id, keep)
+	if err != nil {
+		return err
+	}
+	var versions []int
+	var hashes []string
+	for rows.Next() {
+		var version int
+		var hash string
+		if err := rows.Scan(&version, &hash); err != nil {
+			rows.Close()
+			return err
+		}
+		versions = append(versions, version)
+		hashes = append(hashes, hash)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for i, version := range versions {
+		if _, err := tx.Exec("DELETE FROM cat_pic_versions WHERE cat_pic_id = ? AND version = ?", id, version); err != nil {
+			return err
+		}
+		if err := releaseBlob(tx, hashes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// trashCatPic moves a picture to the trash and reports whether it was found
+// outside of it.
+func trashCatPic(tx dbtx, id string, now time.Time) (bool, error) {
+	res, err := tx.Exec("UPDATE cat_pics SET deleted_at = ? WHERE id = ? AND deleted_at IS NULL", now.UTC().Format(time.RFC3339), id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// catPicTrashed reports whether the picture id is in the trash.
+func catPicTrashed(q dbtx, id string) (bool, error) {
+	var trashed bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM cat_pics WHERE id = ? AND deleted_at IS NOT NULL)", id).Scan(&trashed)
+	return trashed, err
+}
+
+// restoreCatPic takes a picture out of the trash and reports whether it was
+// in it.
+func restoreCatPic(tx dbtx, id string) (bool, error) {
+	res, err := tx.Exec("UPDATE cat_pics SET deleted_at = NULL WHERE id = ? AND deleted_at IS NOT NULL", id)
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n > 0, err
+}
+
+// deleteCatPicByID permanently removes a single cat picture, whether trashed
+// or not, and reports whether a row was deleted. The picture's blob is only
+// dropped with its last reference.
+func deleteCatPicByID(tx dbtx, id string) (bool, error) {
+	var hash string
+	err := tx.QueryRow("SELECT hash FROM cat_pics WHERE id = ?", id).Scan(&hash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	if err := pruneVersions(tx, id, 0); err != nil {
+		return false, err
+	}
+	for _, stmt := range []string{
+		"DELETE FROM cat_pic_tags WHERE cat_pic_id = ?",
+		"DELETE FROM album_pics WHERE cat_pic_id = ?",
+		"DELETE FROM cat_pics WHERE id = ?",
+	} {
+		if _, err := tx.Exec(stmt, id); err != nil {
+			return false, err
+		}
+	}
+	if err := indexCatPic(tx, id); err != nil {
+		return false, err
+	}
+
+	return true, releaseBlob(tx, hash)
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const maxTagLength = 64
+
+var errInvalidTag = errors.New("tag names must be 1-64 characters and may not contain commas or slashes")
+
+type Tag struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+type TagRequest struct {
+	Name string `json:"name"`
+}
+
+// normalizeTag lower-cases and trims a tag name and checks that it is usable
+// both in form fields and in URL paths.
+func normalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || len(name) > maxTagLength || strings.ContainsAny(name, ",/") {
+		return "", errInvalidTag
+	}
+	return name, nil
+}
+
+// parseTagList normalizes tags given as repeated and/or comma-separated values.
+func parseTagList(values []string) ([]string, error) {
+	var tags []string
+	seen := map[string]bool{}
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if strings.TrimSpace(name) == "" {
+				continue
+			}
+			tag, err := normalizeTag(name)
+			if err != nil {
+				return nil, err
+			}
+			if !seen[tag] {
+				seen[tag] = true
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags, nil
+}
+
+// ensureTag returns the ID of the named tag, creating it if necessary.
+func ensureTag(tx dbtx, name string) (int64, error) {
+	if _, err := tx.Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING", name); err != nil {
+		return 0, err
+	}
+	var id int64
+	err := tx.QueryRow("SELECT id FROM tags WHERE name = ?", name).Scan(&id)
+	return id, err
+}
+
+// tagCatPic attaches the named tags to a picture, creating tags as needed.
+func tagCatPic(tx dbtx, id string, tags []string) error {
+	for _, name := range tags {
+		tagID, err := ensureTag(tx, name)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec("INSERT INTO cat_pic_tags (cat_pic_id, tag_id) VALUES (?, ?) ON CONFLICT DO NOTHING", id, tagID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catPicExists reports whether a picture with the given id is stored.
+func catPicExists(q dbtx, id string) (bool, error) {
+	var exists bool
+	err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM cat_pics WHERE id = ? AND deleted_at IS NULL)", id).Scan(&exists)
+	return exists, err
+}
+
+// listTags godoc
+// @Summary List tags
+// @Description List every tag together with the number of pictures carrying it
+// @Tags tags
+// @Produce  json
+// @Success 200 {array} Tag
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/tags [get]
+func ListTags(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := traced(r.Context(), db).Query(`SELECT t.name, COUNT(p.id) FROM tags t
+			LEFT JOIN cat_pic_tags ct ON ct.tag_id = t.id
+			LEFT JOIN cat_pics p ON p.id = ct.cat_pic_id AND p.deleted_at IS NULL
+			GROUP BY t.id ORDER BY t.name`)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		tags := []Tag{}
+		for rows.Next() {
+			var tag Tag
+			if err := rows.Scan(&tag.Name, &tag.Count); err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			tags = append(tags, tag)
+		}
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, tags, http.StatusOK)
+	}
+}
+
+// createTag godoc
+// @Summary Create a tag
+// @Description Create a new, unused tag
+// @Tags tags
+// @Accept  json
+// @Produce  json
+// @Param   tag  body  TagRequest  true  "Tag"
+// @Success 201 {object} Tag
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 409 {object} Problem "Tag already exists"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/tags [post]
+func CreateTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TagRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		name, err := normalizeTag(req.Name)
+		if err != nil {
+			writeProblem(w, r, problemInvalidTagName, "Invalid tag name")
+			return
+		}
+
+		result, err := traced(r.Context(), db).Exec("INSERT INTO tags (name) VALUES (?) ON CONFLICT (name) DO NOTHING", name)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		if n == 0 {
+			writeProblem(w, r, problemTagExists, "Tag already exists")
+			return
+		}
+
+		jsonResponse(w, Tag{Name: name}, http.StatusCreated)
+	}
+}
+
+// renameTag godoc
+// @Summary Rename a tag
+// @Description Rename a tag, keeping its pictures
+// @Tags tags
+// @Accept  json
+// @Produce  json
+// @Param   name  path  string      true  "Tag name"
+// @Param   tag   body  TagRequest  true  "New name"
+// @Success 200 {object} TagRequest
+// @Failure 400 {object} Problem "Invalid request"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Tag already exists"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/tags/{name} [put]
+func RenameTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var req TagRequest
+		if err := decodeJSONBody(w, r, &req); err != nil {
+			writeProblem(w, r, problemInvalidBody, "Invalid request body")
+			return
+		}
+
+		name, err := normalizeTag(req.Name)
+		if err != nil {
+			writeProblem(w, r, problemInvalidTagName, "Invalid tag name")
+			return
+		}
+		old, err := normalizeTag(mux.Vars(r)["name"])
+		if err != nil {
+			writeProblem(w, r, problemTagNotFound, "Tag not found")
+			return
+		}
+
+		// The check and the rename share a transaction so that a concurrent
+		// rename cannot take the name in between.
+		var taken, found bool
+		err = withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			// Renaming a tag to its own name changes nothing, rather than clash.
+			if name != old {
+				if err := q.QueryRow("SELECT EXISTS (SELECT 1 FROM tags WHERE name = ?)", name).Scan(&taken); err != nil || taken {
+					return err
+				}
+			}
+			result, err := q.Exec("UPDATE tags SET name = ? WHERE name = ?", name, old)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			found = n > 0
+			return err
+		})
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		if taken {
+			writeProblem(w, r, problemTagExists, "Tag already exists")
+			return
+		}
+		if !found {
+			writeProblem(w, r, problemTagNotFound, "Tag not found")
+			return
+		}
+
+		jsonResponse(w, TagRequest{Name: name}, http.StatusOK)
+	}
+}
+
+// deleteTag godoc
+// @Summary Delete a tag
+// @Description Delete a tag and remove it from every picture
+// @Tags tags
+// @Param   name  path  string  true  "Tag name"
+// @Success 204 "No Content"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/tags/{name} [delete]
+func DeleteTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name, err := normalizeTag(mux.Vars(r)["name"])
+		if err != nil {
+			writeProblem(w, r, problemTagNotFound, "Tag not found")
+			return
+		}
+
+		var found bool
+		err = withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			if _, err := q.Exec("DELETE FROM cat_pic_tags WHERE tag_id IN (SELECT id FROM tags WHERE name = ?)", name); err != nil {
+				return err
+			}
+			result, err := q.Exec("DELETE FROM tags WHERE name = ?", name)
+			if err != nil {
+				return err
+			}
+			n, err := result.RowsAffected()
+			found = n > 0
+			return err
+		})
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		if !found {
+			writeProblem(w, r, problemTagNotFound, "Tag not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// listCatPicTags godoc
+// @Summary List a picture's tags
+// @Description List the tags attached to a cat picture
+// @Tags tags
+// @Produce  json
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Success 200 {array} string
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/tags [get]
+func ListCatPicTags(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		exists, err := catPicExists(traced(r.Context(), db), id)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		if !exists {
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		}
+
+		rows, err := traced(r.Context(), db).Query(`SELECT t.name FROM tags t
+			JOIN cat_pic_tags ct ON ct.tag_id = t.id
+			WHERE ct.cat_pic_id = ? ORDER BY t.name`, id)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		tags := []string{}
+		for rows.Next() {
+			var name string
+			if err := rows.Scan(&name); err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			tags = append(tags, name)
+		}
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, tags, http.StatusOK)
+	}
+}
+
+// addCatPicTag godoc
+// @Summary Tag a picture
+// @Description Attach a tag to a cat picture, creating the tag if it does not exist yet
+// @Tags tags
+// @Param   id   path  string  true  "Cat Picture ID"
+// @Param   tag  path  string  true  "Tag name"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid tag name"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/tags/{tag} [put]
+func AddCatPicTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+		id := vars["id"]
+
+		tag, err := normalizeTag(vars["tag"])
+		if err != nil {
+			writeProblem(w, r, problemInvalidTagName, "Invalid tag name")
+			return
+		}
+
+		var exists bool
+		err = withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			if exists, err = catPicExists(q, id); err != nil || !exists {
+				return err
+			}
+			return tagCatPic(q, id, []string{tag})
+		})
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		if !exists {
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// removeCatPicTag godoc
+// @Summary Untag a picture
+// @Description Detach a tag from a cat picture
+// @Tags tags
+// @Param   id   path  string  true  "Cat Picture ID"
+// @Param   tag  path  string  true  "Tag name"
+// @Success 204 "No Content"
+// @Failure 400 {object} Problem "Invalid tag name"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/tags/{tag} [delete]
+func RemoveCatPicTag(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		vars := mux.Vars(r)
+
+		tag, err := normalizeTag(vars["tag"])
+		if err != nil {
+			writeProblem(w, r, problemInvalidTagName, "Invalid tag name")
+			return
+		}
+
+		result, err := traced(r.Context(), db).Exec(`DELETE FROM cat_pic_tags WHERE cat_pic_id = ?
+			AND tag_id IN (SELECT id FROM tags WHERE name = ?)`, vars["id"], tag)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		n, err := result.RowsAffected()
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		if n == 0 {
+			writeProblem(w, r, problemCatPicNotTagged, "Cat picture does not carry this tag")
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// instrumentationName names the tracer all spans come from.
+const instrumentationName = "github.com/sid0jack/catpics-api"
+
+// tracer returns the tracer of the current global provider. It is looked up
+// every time rather than kept, so that replacing the provider takes effect.
+func tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// setupTracing installs the global tracer provider for the exporter named by
+// config.TraceExporter and W3C Trace Context propagation. The OTLP exporter
+// sends to a collector configured through the standard OTEL_EXPORTER_OTLP_*
+// variables, http://localhost:4318 by default. The returned function flushes
+// and stops the exporter.
+func setupTracing(ctx context.Context) (shutdown func(context.Context) error, err error) {
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	var exporter sdktrace.SpanExporter
+	switch config.TraceExporter {
+	case traceExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case traceExporterOTLP:
+		exporter, err = otlptracehttp.New(ctx)
+	case traceExporterStdout:
+		exporter, err = stdouttrace.New(stdouttrace.WithWriter(os.Stdout))
+	default:
+		err = fmt.Errorf("unknown trace exporter %q", config.TraceExporter)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the defaults.
+	res, err := resource.New(ctx,
+		resource.WithAttributes(attribute.String("service.name", "catpics-api")),
+		resource.WithTelemetrySDK(),
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
+
+// traceRoute is router middleware that continues the trace of an incoming
+// traceparent header, or starts a new one, with a server span named after
+// the matched route.
+func traceRoute(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := r.URL.Path
+		if current := mux.CurrentRoute(r); current != nil {
+			route, _ = current.GetPathTemplate()
+		}
+
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer().Start(ctx, r.Method+" "+route,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("http.route", route),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("request.id", requestID(r)),
+			))
+		defer span.End()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		next.ServeHTTP(rec, r.WithContext(ctx))
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", rec.status))
+		if rec.status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(rec.status))
+		}
+	})
+}
+
+// startSpan starts an internal child span, for image operations and other
+// work worth seeing in a trace. Outside a trace, such as during imports from
+// the command line, it does nothing rather than start a trace per call.
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx, trace.SpanFromContext(ctx)
+	}
+	return tracer().Start(ctx, name)
+}
+
+// endSpan records err, if any, on span and ends it.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// contextQuerier is satisfied by both *sql.DB and *sql.Tx.
+type contextQuerier interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// tracedQuerier runs statements in the context of a request, each in a
+// child span of it.
+type tracedQuerier struct {
+	ctx context.Context
+	q   contextQuerier
+}
+
+// traced binds q to ctx, so that the statements run through the returned
+// dbtx show up in ctx's trace.
+func traced(ctx context.Context, q contextQuerier) dbtx {
+	return tracedQuerier{ctx: ctx, q: q}
+}
+
+// queryContext returns the context q runs its statements in.
+func queryContext(q dbtx) context.Context {
+	if t, ok := q.(tracedQuerier); ok {
+		return t.ctx
+	}
+	return context.Background()
+}
+
+func (t tracedQuerier) startSQLSpan(query string) (context.Context, trace.Span) {
+	operation := "SQL"
+	if fields := strings.Fields(query); len(fields) > 0 {
+		operation = strings.ToUpper(fields[0])
+	}
+	return tracer().Start(t.ctx, operation,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "sqlite"),
+			attribute.String("db.statement", query),
+		))
+}
+
+func (t tracedQuerier) Exec(query string, args ...interface{}) (sql.Result, error) {
+	ctx, span := t.startSQLSpan(query)
+	res, err := t.q.ExecContext(ctx, query, args...)
+	endSpan(span, err)
+	return res, err
+}
+
+func (t tracedQuerier) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	ctx, span := t.startSQLSpan(query)
+	rows, err := t.q.QueryContext(ctx, query, args...)
+	endSpan(span, err)
+	return rows, err
+}
+
+func (t tracedQuerier) QueryRow(query string, args ...interface{}) *sql.Row {
+	ctx, span := t.startSQLSpan(query)
+	row := t.q.QueryRowContext(ctx, query, args...)
+	endSpan(span, row.Err())
+	return row
+}
@@ -0,0 +1,339 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"image"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	maxTransformOps = 10
+	maxBlurRadius   = 20
+	// maxTransformWork bounds the pixels a pipeline may touch, counting each
+	// pass over the image once. The decode counts as one pass.
+	maxTransformWork = 100_000_000
+)
+
+// transformOp is one step of a transformation pipeline, e.g. crop:0,0,10,10.
+type transformOp struct {
+	name string
+	args []int
+}
+
+func (op transformOp) String() string {
+	if op.name == "flip" {
+		return "flip:" + [...]string{"h", "v"}[op.args[0]]
+	}
+	if len(op.args) == 0 {
+		return op.name
+	}
+	args := make([]string, len(op.args))
+	for i, a := range op.args {
+		args[i] = strconv.Itoa(a)
+	}
+	return op.name + ":" + strings.Join(args, ",")
+}
+
+// transformOpArgs lists the number of integer arguments each op takes.
+var transformOpArgs = map[string]int{
+	"crop":      4, // x,y,width,height
+	"rotate":    1, // 90, 180 or 270 degrees clockwise
+	"flip":      1, // h or v, stored as 0 or 1
+	"grayscale": 0,
+	"blur":      1, // radius in pixels
+}
+
+// parseTransformOps parses a pipeline of the form op[:arg,...]|op[:arg,...].
+func parseTransformOps(s string) ([]transformOp, error) {
+	if s == "" {
+		return nil, fmt.Errorf("ops must not be empty")
+	}
+	parts := strings.Split(s, "|")
+	if len(parts) > maxTransformOps {
+		return nil, fmt.Errorf("at most %d ops are allowed", maxTransformOps)
+	}
+
+	ops := make([]transformOp, 0, len(parts))
+	for _, part := range parts {
+		name, rawArgs, hasArgs := strings.Cut(strings.TrimSpace(part), ":")
+		want, ok := transformOpArgs[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown op %q", name)
+		}
+		op := transformOp{name: name}
+
+		switch {
+		case name == "flip":
+			switch rawArgs {
+			case "h":
+				op.args = []int{0}
+			case "v":
+				op.args = []int{1}
+			default:
+				return nil, fmt.Errorf("flip takes h or v")
+			}
+		case hasArgs:
+			for _, a := range strings.Split(rawArgs, ",") {
+				n, err := strconv.Atoi(strings.TrimSpace(a))
+				if err != nil {
+					return nil, fmt.Errorf("%s: %q is not an integer", name, a)
+				}
+				op.args = append(op.args, n)
+			}
+		}
+		if len(op.args) != want {
+			return nil, fmt.Errorf("%s takes %d arguments", name, want)
+		}
+
+		switch name {
+		case "crop":
+			if op.args[0] < 0 || op.args[1] < 0 || op.args[2] < 1 || op.args[3] < 1 {
+				return nil, fmt.Errorf("crop needs a non-negative offset and a positive size")
+			}
+		case "rotate":
+			if a := op.args[0]; a != 90 && a != 180 && a != 270 {
+				return nil, fmt.Errorf("rotate takes 90, 180 or 270")
+			}
+		case "blur":
+			if r := op.args[0]; r < 1 || r > maxBlurRadius {
+				return nil, fmt.Errorf("blur radius must be between 1 and %d", maxBlurRadius)
+			}
+		}
+		ops = append(ops, op)
+	}
+	return ops, nil
+}
+
+// canonicalOps formats ops the same way regardless of how they were written.
+func canonicalOps(ops []transformOp) string {
+	parts := make([]string, len(ops))
+	for i, op := range ops {
+		parts[i] = op.String()
+	}
+	return strings.Join(parts, "|")
+}
+
+// planTransform checks ops against a w x h picture without decoding it and
+// returns the work the pipeline would do.
+func planTransform(ops []transformOp, w, h int) (int, error) {
+	work := w * h
+	for _, op := range ops {
+		switch op.name {
+		case "crop":
+			x, y, cw, ch := op.args[0], op.args[1], op.args[2], op.args[3]
+			if cw > w || ch > h || x > w-cw || y > h-ch {
+				return 0, fmt.Errorf("%s is outside the %dx%d picture", op, w, h)
+			}
+			w, h = cw, ch
+		case "rotate":
+			if op.args[0] != 180 {
+				w, h = h, w
+			}
+		case "blur":
+			// Three horizontal and three vertical box passes.
+			work += 5 * w * h
+		}
+		work += w * h
+	}
+	return work, nil
+}
+
+// applyTransform runs ops on img. The ops must have been checked with
+// planTransform.
+func applyTransform(img image.Image, ops []transformOp) image.Image {
+	dst := toNRGBA(img)
+	for _, op := range ops {
+		switch op.name {
+		case "crop":
+			r := image.Rect(op.args[0], op.args[1], op.args[0]+op.args[2], op.args[1]+op.args[3])
+			dst = toNRGBA(dst.SubImage(r))
+		case "rotate":
+			dst = orientImage(dst, map[int]int{90: 6, 180: 3, 270: 8}[op.args[0]]).(*image.NRGBA)
+		case "flip":
+			dst = orientImage(dst, [...]int{2, 4}[op.args[0]]).(*image.NRGBA)
+		case "grayscale":
+			grayscale(dst)
+		case "blur":
+			for i := 0; i < 3; i++ {
+				boxBlur(dst, op.args[0], false)
+				boxBlur(dst, op.args[0], true)
+			}
+		}
+	}
+	return dst
+}
+
+// grayscale converts img to shades of grey in place, keeping alpha.
+func grayscale(img *image.NRGBA) {
+	for i := 0; i+3 < len(img.Pix); i += 4 {
+		r, g, b := uint32(img.Pix[i]), uint32(img.Pix[i+1]), uint32(img.Pix[i+2])
+		y := uint8((19595*r + 38470*g + 7471*b + 1<<15) >> 16)
+		img.Pix[i], img.Pix[i+1], img.Pix[i+2] = y, y, y
+	}
+}
+
+// boxBlur averages every pixel with its neighbours within radius along the
+// rows, or the columns if vertical, in place.
+func boxBlur(img *image.NRGBA, radius int, vertical bool) {
+	// step is the distance in bytes between neighbouring pixels of a line and
+	// lineStep the distance between the starts of consecutive lines.
+	length, lines, step, lineStep := img.Rect.Dx(), img.Rect.Dy(), 4, img.Stride
+	if vertical {
+		length, lines, step, lineStep = lines, length, lineStep, step
+	}
+
+	line := make([]uint8, length*4)
+	for l := 0; l < lines; l++ {
+		start := l * lineStep
+		for i := 0; i < length; i++ {
+			copy(line[i*4:i*4+4], img.Pix[start+i*step:])
+		}
+
+		for c := 0; c < 4; c++ {
+			// Sliding window sum with edge pixels repeated.
+			at := func(i int) int {
+				if i < 0 {
+					i = 0
+				} else if i >= length {
+					i = length - 1
+				}
+				return int(line[i*4+c])
+			}
+			sum := 0
+			for i := -radius; i <= radius; i++ {
+				sum += at(i)
+			}
+			for i := 0; i < length; i++ {
+				img.Pix[start+i*step+c] = uint8((sum + radius) / (2*radius + 1))
+				sum += at(i+radius+1) - at(i-radius)
+			}
+		}
+	}
+}
+
+// transformCatPic godoc
+// @Summary Transform a cat picture
+// @Description Apply a pipeline of image operations, separated by |, and return the result in the picture's stored format (PNG for formats other than JPEG). Operations: crop:x,y,width,height; rotate:90|180|270 (clockwise); flip:h|v; grayscale; blur:radius (1-20). At most 10 operations are allowed and the total work is bounded. Responses carry an ETag derived from the picture and the operations.
+// @Tags catpics
+// @Produce  jpeg,png
+// @Param   id   path   string  true  "Cat Picture ID"
+// @Param   ops  query  string  true  "Operations, e.g. crop:10,10,200,200|rotate:90|grayscale"
+// @Param   If-None-Match  header  string  false  "ETag of a cached result"
+// @Success 200  {file}    binary
+// @Success 304  "Cached result is still valid"
+// @Failure 400  {object}  Problem            "Invalid operations"
+// @Failure 404  {object}  Problem
+// @Failure 422  {object}  Problem            "Picture is not a decodable image, exceeds the dimension limits, or the operations are too expensive"
+// @Failure 500  {object}  Problem
+// @Router /v1/catpics/{id}/transform [get]
+func TransformCatPic(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		ops, err := parseTransformOps(r.URL.Query().Get("ops"))
+		if err != nil {
+			writeProblem(w, r, problemInvalidParameter, "Invalid ops: "+err.Error())
+			return
+		}
+		canonical := canonicalOps(ops)
+
+		hash, data, err := loadCatPicBlob(traced(r.Context(), db), id)
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		case err != nil:
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		contentType := "image/png"
+		if http.DetectContentType(data) == "image/jpeg" {
+			contentType = "image/jpeg"
+		}
+
+		key := "transform|" + hash + "|" + canonical
+		out, ok := convertedImages.get(key)
+		var img image.Image
+		if !ok {
+			cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+			if err != nil {
+				writeProblem(w, r, problemUndecodableImage, "Cat picture is not a decodable image")
+				return
+			}
+			if err := checkImageSize(cfg.Width, cfg.Height); err != nil {
+				writeProblem(w, r, problemImageTooLarge, imageTooLargeMessage(err))
+				return
+			}
+			work, err := planTransform(ops, cfg.Width, cfg.Height)
+			if err != nil {
+				writeProblem(w, r, problemInvalidParameter, "Invalid ops: "+err.Error())
+				return
+			}
+			if work > maxTransformWork {
+				writeProblem(w, r, problemTransformTooExpensive, "The requested transformation is too expensive for this picture")
+				return
+			}
+
+			_, span := startSpan(r.Context(), "decodeImage")
+			img, _, err = decodeImage(data)
+			endSpan(span, err)
+			if err != nil {
+				writeProblem(w, r, problemUndecodableImage, "Cat picture is not a decodable image")
+				return
+			}
+		}
+
+		// The output only depends on the stored bytes and the ops, and the
+		// encoders are deterministic, so once the picture is known to decode
+		// and the ops to apply, a cached result is revalidated without
+		// running them.
+		sum := sha256.Sum256([]byte(id + "\x00" + hash + "\x00" + canonical))
+		etag := `"` + hex.EncodeToString(sum[:16]) + `"`
+		w.Header().Set("ETag", etag)
+		if etagMatches(r.Header.Get("If-None-Match"), etag) {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+
+		if !ok {
+			_, span := startSpan(r.Context(), "applyTransform")
+			img = applyTransform(img, ops)
+			span.End()
+			_, span = startSpan(r.Context(), "encodeImage")
+			out, err = encodeImage(img, contentType, reencodeJPEGQuality)
+			endSpan(span, err)
+			if err != nil {
+				w.Header().Del("ETag")
+				serverError(w, r, "Server error", err)
+				return
+			}
+			convertedImages.put(key, out)
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		if _, err := w.Write(out); err != nil {
+			logError(r, "Error writing image to response", err)
+		}
+	}
+}
+
+// etagMatches reports whether an If-None-Match or If-Match header lists etag.
+// Weak validators compare equal to their strong counterparts.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == etag {
+			return true
+		}
+	}
+	return false
+}
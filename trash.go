@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+// trashPurgeInterval is how often the purger looks for expired pictures.
+const trashPurgeInterval = time.Hour
+
+// TrashedCatPic is a deleted picture that can still be restored.
+type TrashedCatPic struct {
+	CatPicResponse
+	DeletedAt string `json:"deletedAt"`
+	// PurgeAt is when the picture will be deleted permanently.
+	PurgeAt string `json:"purgeAt"`
+}
+
+// listTrash godoc
+// @Summary List deleted cat pictures
+// @Description List the pictures in the trash, most recently deleted first, with the time each will be purged
+// @Tags catpics
+// @Produce  json
+// @Success 200 {array} TrashedCatPic
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/trash [get]
+func ListTrash(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rows, err := traced(r.Context(), db).Query("SELECT " + catPicResponseColumns + `, p.deleted_at FROM cat_pics p
+			WHERE p.deleted_at IS NOT NULL
+			ORDER BY p.deleted_at DESC, p.id`)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		pics := []TrashedCatPic{}
+		for rows.Next() {
+			var pic TrashedCatPic
+			var err error
+			pic.CatPicResponse, err = scanCatPicResponse(rows, &pic.DeletedAt)
+			if err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			if deletedAt, err := time.Parse(time.RFC3339, pic.DeletedAt); err == nil {
+				pic.PurgeAt = deletedAt.Add(config.TrashRetention).Format(time.RFC3339)
+			}
+			pics = append(pics, pic)
+		}
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, pics, http.StatusOK)
+	}
+}
+
+// restoreCatPic godoc
+// @Summary Restore a deleted cat picture
+// @Description Take a cat picture out of the trash
+// @Tags catpics
+// @Produce  json
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Success 200 {object} CatPicEnvelope
+// @Failure 404 {object} Problem "Not in the trash"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/restore [post]
+func RestoreCatPic(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		var pic CatPicResponse
+		var restored bool
+		err := withTx(r.Context(), db, func(tx *sql.Tx) (err error) {
+			q := traced(r.Context(), tx)
+			if restored, err = restoreCatPic(q, id); err != nil || !restored {
+				return err
+			}
+			pic, err = scanCatPicResponse(q.QueryRow("SELECT "+catPicResponseColumns+" FROM cat_pics p WHERE p.id = ?", id))
+			return err
+		})
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		if !restored {
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found in the trash")
+			return
+		}
+
+		writeCatPic(w, r, pic, http.StatusOK)
+	}
+}
+
+// purgeTrash permanently deletes the pictures trashed at or before cutoff and
+// returns their IDs.
+func purgeTrash(db *sql.DB, cutoff time.Time) ([]string, error) {
+	var ids []string
+	err := withTx(context.Background(), db, func(tx *sql.Tx) error {
+		rows, err := tx.Query("SELECT id FROM cat_pics WHERE deleted_at IS NOT NULL AND deleted_at <= ?", cutoff.UTC().Format(time.RFC3339))
+		if err != nil {
+			return err
+		}
+		for rows.Next() {
+			var id string
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range ids {
+			if _, err := deleteCatPicByID(tx, id); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return ids, err
+}
+
+// startTrashPurger purges pictures older than retention from the trash now
+// and then every trashPurgeInterval, until the returned function is called.
+func startTrashPurger(db *sql.DB, retention time.Duration) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+		for {
+			ids, err := purgeTrash(db, time.Now().Add(-retention))
+			switch {
+			case err != nil:
+				slog.Error("Error purging trash", "error", err)
+			case len(ids) > 0:
+				slog.Info("Purged cat pictures from the trash", "count", len(ids))
+			}
+
+			select {
+			case <-ticker.C:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+)
+
+// CatPicVersion describes one stored version of a picture's bytes.
+type CatPicVersion struct {
+	Version int  `json:"version"`
+	Size    int  `json:"size"`
+	Current bool `json:"current"`
+	// ReplacedAt is when a previous version was superseded; empty for the
+	// current one.
+	ReplacedAt string `json:"replacedAt,omitempty"`
+}
+
+// currentVersion returns the version number of a picture outside the trash.
+func currentVersion(q dbtx, id string) (int, error) {
+	var version int
+	err := q.QueryRow("SELECT version FROM cat_pics WHERE id = ? AND deleted_at IS NULL", id).Scan(&version)
+	return version, err
+}
+
+// loadCatPicVersion returns the bytes of version n of a picture outside the
+// trash, whether n is the current version or a previous one.
+func loadCatPicVersion(q dbtx, id string, n int) ([]byte, error) {
+	var data []byte
+	err := q.QueryRow(`SELECT b.data FROM cat_pics p JOIN blobs b ON b.hash = p.hash
+		WHERE p.id = ? AND p.deleted_at IS NULL AND p.version = ?
+		UNION ALL
+		SELECT b.data FROM cat_pic_versions v
+		JOIN cat_pics p ON p.id = v.cat_pic_id
+		JOIN blobs b ON b.hash = v.hash
+		WHERE v.cat_pic_id = ? AND p.deleted_at IS NULL AND v.version = ?`, id, n, id, n).Scan(&data)
+	return data, err
+}
+
+// versionParam parses the {n} path variable, writing a 400 if it is not a
+// positive integer.
+func versionParam(w http.ResponseWriter, r *http.Request) (int, bool) {
+	n, err := strconv.Atoi(mux.Vars(r)["n"])
+	if err != nil || n < 1 {
+		writeProblem(w, r, problemInvalidParameter, "Invalid version")
+		return 0, false
+	}
+	return n, true
+}
+
+// listCatPicVersions godoc
+// @Summary List the versions of a cat picture
+// @Description List the current version of a picture followed by the previous ones kept by updates, newest first. At most CATPICS_MAX_VERSIONS previous versions are kept.
+// @Tags catpics
+// @Produce  json
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Success 200 {array} CatPicVersion
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/versions [get]
+func ListCatPicVersions(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+
+		current := CatPicVersion{Current: true}
+		err := traced(r.Context(), db).QueryRow(`SELECT p.version, length(b.data) FROM cat_pics p JOIN blobs b ON b.hash = p.hash
+			WHERE p.id = ? AND p.deleted_at IS NULL`, id).Scan(&current.Version, &current.Size)
+		if err == sql.ErrNoRows {
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		rows, err := traced(r.Context(), db).Query(`SELECT v.version, length(b.data), v.replaced_at FROM cat_pic_versions v
+			JOIN blobs b ON b.hash = v.hash
+			WHERE v.cat_pic_id = ?
+			ORDER BY v.version DESC`, id)
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+		defer rows.Close()
+
+		versions := []CatPicVersion{current}
+		for rows.Next() {
+			var v CatPicVersion
+			if err := rows.Scan(&v.Version, &v.Size, &v.ReplacedAt); err != nil {
+				serverError(w, r, "Server error", err)
+				return
+			}
+			versions = append(versions, v)
+		}
+
+		if err := rows.Err(); err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		jsonResponse(w, versions, http.StatusOK)
+	}
+}
+
+// getCatPicVersion godoc
+// @Summary Get a version of a cat picture
+// @Description Get the bytes of the current or a previous version of a picture
+// @Tags catpics
+// @Produce  octet-stream
+// @Param   id  path  string  true  "Cat Picture ID"
+// @Param   n   path  int     true  "Version number"
+// @Success 200 {file} binary
+// @Failure 400 {object} Problem "Invalid version"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/versions/{n} [get]
+func GetCatPicVersion(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		n, ok := versionParam(w, r)
+		if !ok {
+			return
+		}
+
+		data, err := loadCatPicVersion(traced(r.Context(), db), id, n)
+		if err == sql.ErrNoRows {
+			writeProblem(w, r, problemVersionNotFound, "Cat picture version not found")
+			return
+		}
+		if err != nil {
+			serverError(w, r, "Server error", err)
+			return
+		}
+
+		w.Header().Set("Content-Type", http.DetectContentType(data))
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(servedImage(data)); err != nil {
+			logError(r, "Error writing image to response", err)
+		}
+	}
+}
+
+// restoreCatPicVersion godoc
+// @Summary Restore a previous version of a cat picture
+// @Description Make a previous version the picture's bytes again. This is an update like any other, so the version it replaces is kept in the history and the restored bytes get a new version number. If-Match works as for updates.
+// @Tags catpics
+// @Produce  json
+// @Param   id        path    string  true   "Cat Picture ID"
+// @Param   n         path    int     true   "Version number"
+// @Param   If-Match  header  string  false  "ETag or version number the picture must still have"
+// @Success 200 {object} CatPicVersion
+// @Failure 400 {object} Problem "Invalid version"
+// @Failure 404 {object} Problem "Not Found"
+// @Failure 409 {object} Problem "Already the current version"
+// @Failure 412 {object} Problem "The picture no longer matches If-Match"
+// @Failure 428 {object} Problem "If-Match is required"
+// @Failure 500 {object} Problem "Internal Server Error"
+// @Router /v1/catpics/{id}/versions/{n}/restore [post]
+func RestoreCatPicVersion(db *sql.DB) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := mux.Vars(r)["id"]
+		n, ok := versionParam(w, r)
+		if !ok {
+			return
+		}
+		if !requireIfMatch(w, r) {
+			return
+		}
+
+		var found, matched, current bool
+		var restored CatPicVersion
+		err := withTx(r.Context(), db, func(tx *sql.Tx) error {
+			q := traced(r.Context(), tx)
+			var err error
+			found, matched, err = checkPreconditions(q, id, r)
+			if err != nil || !found || !matched {
+				return err
+			}
+			version, err := currentVersion(q, id)
+			if err != nil {
+				return err
+			}
+			if current = version == n; current {
+				return nil
+			}
+
+			data, err := loadCatPicVersion(q, id, n)
+			if err != nil {
+				return err
+			}
+			// An older version can hold the current bytes again after an
+			// A→B→A history; restoring it would change nothing.
+			var hash string
+			if err := q.QueryRow("SELECT hash FROM cat_pics WHERE id = ?", id).Scan(&hash); err != nil {
+				return err
+			}
+			if current = contentHash(data) == hash; current {
+				return nil
+			}
+			if _, err := replaceCatPicData(q, id, data); err != nil {
+				return err
+			}
+
+			restored = CatPicVersion{Size: len(data), Current: true}
+			restored.Version, err = currentVersion(q, id)
+			return err
+		})
+		switch {
+		case err == sql.ErrNoRows:
+			writeProblem(w, r, problemVersionNotFound, "Cat picture version not found")
+			return
+		case err != nil:
+			serverError(w, r, "Server error", err)
+			return
+		case !found:
+			writeProblem(w, r, problemCatPicNotFound, "Cat picture not found")
+			return
+		case !matched:
+			writeProblem(w, r, problemPreconditionFailed, "Cat picture has been modified")
+			return
+		case current:
+			writeProblem(w, r, problemVersionCurrent, "Version is already current")
+			return
+		}
+
+		w.Header().Set("ETag", pictureETag(restored.Version))
+		jsonResponse(w, restored, http.StatusOK)
+	}
+}